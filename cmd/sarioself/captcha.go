@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aryahadii/sarioself/configuration"
+	"github.com/aryahadii/sarioself/selfservice"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	captchaCmd = &cobra.Command{
+		Use:   "captcha <subcommand>",
+		Short: "Collect captcha datasets and benchmark captcha solvers",
+		Run:   nil,
+	}
+
+	captchaCollectCmd = &cobra.Command{
+		Use:   "collect",
+		Short: "Save Samad captchas with guess of captcha solver and login result",
+		Run:   captchaCollect,
+	}
+
+	captchaBenchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Measure accuracy and latency of captcha solvers on a labeled dataset",
+		Run:   captchaBench,
+	}
+)
+
+func init() {
+	captchaCmd.PersistentFlags().String("dataset-dir", "", "captcha dataset directory (default samad.captcha-dataset-dir)")
+
+	captchaCollectCmd.Flags().Int("count", 10, "number of captchas to collect")
+	captchaCollectCmd.Flags().String("solver", "", "captcha solver which guesses captchas (default samad.captcha-solver)")
+	captchaCollectCmd.Flags().String("provider", "", "name of Samad provider (default samad.default-provider)")
+	captchaCollectCmd.Flags().String("username", "", "Samad username (default test-user.username)")
+	captchaCollectCmd.Flags().String("password", "", "Samad password (default test-user.password)")
+
+	captchaBenchCmd.Flags().String("solvers", "", "comma separated captcha solvers (default all of them)")
+
+	captchaCmd.AddCommand(captchaCollectCmd)
+	captchaCmd.AddCommand(captchaBenchCmd)
+	rootCmd.AddCommand(captchaCmd)
+}
+
+// getFlagOrConfig returns value of string flag, or value of config key if the
+// flag isn't set
+func getFlagOrConfig(cmd *cobra.Command, flag, key string) string {
+	if value, _ := cmd.Flags().GetString(flag); len(value) > 0 {
+		return value
+	}
+	return configuration.SarioselfConfig.GetString(key)
+}
+
+func getSamadProvider(name string) (selfservice.SamadProvider, error) {
+	providers, err := selfservice.LoadSamadProviders(configuration.SarioselfConfig)
+	if err != nil {
+		return selfservice.SamadProvider{}, err
+	}
+	provider, ok := providers[name]
+	if !ok {
+		return selfservice.SamadProvider{}, fmt.Errorf("Samad provider %v isn't defined", name)
+	}
+	return provider, nil
+}
+
+func captchaCollect(cmd *cobra.Command, args []string) {
+	datasetDir := getFlagOrConfig(cmd, "dataset-dir", "samad.captcha-dataset-dir")
+	solverName := getFlagOrConfig(cmd, "solver", "samad.captcha-solver")
+	username := getFlagOrConfig(cmd, "username", "test-user.username")
+	password := getFlagOrConfig(cmd, "password", "test-user.password")
+	providerName := getFlagOrConfig(cmd, "provider", "samad.default-provider")
+	count, _ := cmd.Flags().GetInt("count")
+
+	// The first samples are collected without a solver and labeled by hand
+	solver, err := selfservice.NewCaptchaSolver(solverName, selfservice.LoadCaptchaSolverConfig(configuration.SarioselfConfig))
+	if err != nil {
+		logrus.WithError(err).Warnln("captchas are collected without guesses")
+	}
+	provider, err := getSamadProvider(providerName)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+
+	var loggedIn int
+	for i := 0; i < count; i++ {
+		sample, err := selfservice.CollectCaptchaSample(context.Background(), provider, username, password, solver)
+		if err != nil {
+			logrus.Fatalf("can't collect captcha sample, %v", err)
+		}
+		path, err := selfservice.SaveCaptchaSample(datasetDir, sample)
+		if err != nil {
+			logrus.Fatalf("can't save captcha sample, %v", err)
+		}
+		if sample.LoggedIn {
+			loggedIn++
+		}
+		fmt.Printf("%s\tguess=%q\tlogged-in=%v\n", path, sample.Guess, sample.LoggedIn)
+	}
+	fmt.Printf("%d of %d guesses were accepted by Samad\n", loggedIn, count)
+}
+
+func captchaBench(cmd *cobra.Command, args []string) {
+	datasetDir := getFlagOrConfig(cmd, "dataset-dir", "samad.captcha-dataset-dir")
+	solverNames := selfservice.CaptchaSolverNames()
+	if value, _ := cmd.Flags().GetString("solvers"); len(value) > 0 {
+		solverNames = strings.Split(value, ",")
+	}
+
+	captchas, err := selfservice.ReadCaptchaDataset(datasetDir)
+	if err != nil {
+		logrus.Fatalln(err)
+	}
+	if len(captchas) == 0 {
+		logrus.Fatalf("there isn't any labeled captcha in %v", datasetDir)
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "SOLVER\tSAMPLES\tCORRECT\tFAILURES\tACCURACY\tMEAN LATENCY")
+	for _, solverName := range solverNames {
+		solver, err := selfservice.NewCaptchaSolver(strings.TrimSpace(solverName), selfservice.LoadCaptchaSolverConfig(configuration.SarioselfConfig))
+		if err != nil {
+			logrus.WithError(err).Errorf("can't create %v captcha solver", solverName)
+			continue
+		}
+
+		benchmark := selfservice.BenchmarkCaptchaSolver(solver, captchas)
+		fmt.Fprintf(writer, "%s\t%d\t%d\t%d\t%.1f%%\t%v\n", solverName, benchmark.Samples,
+			benchmark.Correct, benchmark.Failures, benchmark.Accuracy()*100, benchmark.MeanLatency())
+	}
+	writer.Flush()
+}
@@ -22,6 +22,21 @@ func LoadConfig() error {
 
 	SarioselfConfig.SetDefault("address", "localhost:8000")
 	SarioselfConfig.SetDefault("debug", true)
+	SarioselfConfig.SetDefault("bots.telegram.request-timeout", 60)
+	SarioselfConfig.SetDefault("samad.session-timeout", 20)
+	SarioselfConfig.SetDefault("samad.request-timeout", 10)
+	SarioselfConfig.SetDefault("samad.retries", 2)
+	SarioselfConfig.SetDefault("samad.retry-backoff", 500)
+	SarioselfConfig.SetDefault("samad.default-provider", "aut")
+	SarioselfConfig.SetDefault("samad.captcha-attempts", 3)
+	SarioselfConfig.SetDefault("samad.captcha-solver", "template")
+	SarioselfConfig.SetDefault("samad.manual-captcha", true)
+	SarioselfConfig.SetDefault("samad.captcha-samples-dir", "captcha-samples")
+	SarioselfConfig.SetDefault("samad.captcha-dataset-dir", "captcha-dataset")
+	SarioselfConfig.SetDefault("samad.captcha-preprocessing.min-neighbours", 2)
+	SarioselfConfig.SetDefault("samad.captcha-preprocessing.margin", 2)
+	SarioselfConfig.SetDefault("diagnostics.dir", "diagnostics")
+	SarioselfConfig.SetDefault("diagnostics.alert-interval", 60)
 
 	return nil
 }
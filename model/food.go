@@ -28,11 +28,34 @@ const (
 
 // Food contains information of a food
 type Food struct {
-	Name        string
-	SideDish    string
-	PriceTooman int
-	MealTime    MealTime
-	Status      FoodStatus
-	Date        *time.Time
-	ID          string
+	Name     string
+	SideDish string
+	Price    Money
+	MealTime MealTime
+	Status   FoodStatus
+	Date     *time.Time
+	ID       string
+	// ServedUntil is end of serving window of food, Date is its start
+	ServedUntil *time.Time
+	// SelfID is ID of the restaurant which serves food
+	SelfID string
+	// Count is number of reserved portions of food
+	Count int
+	// MaxCount is the most portions of food which can be reserved
+	MaxCount int
+	// ReserveID is ID of user's reservation of food in Samad, it's empty if
+	// food isn't reserved
+	ReserveID string
+	// ProgramID, FoodTypeID and MealTypeID identify food in program of self
+	ProgramID  string
+	FoodTypeID string
+	MealTypeID string
+	// ChangeableUntil is the time after which reservation of food can't be
+	// changed, it's nil if the deadline is unknown
+	ChangeableUntil *time.Time
+}
+
+// IsChangeable checks whether reservation of food can be changed at t
+func (f *Food) IsChangeable(t time.Time) bool {
+	return f.ChangeableUntil == nil || t.Before(*f.ChangeableUntil)
 }
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Money is an amount of money in Rials, which is the currency Samad shows
+// prices and credits in
+type Money int
+
+const (
+	persianDigits            = "۰۱۲۳۴۵۶۷۸۹"
+	persianThousandSeparator = "٬"
+	persianDecimalSeparator  = "٫"
+)
+
+// Rials creates Money of amount Rials
+func Rials(amount int) Money {
+	return Money(amount)
+}
+
+// Tomans creates Money of amount Tomans, each Toman is 10 Rials
+func Tomans(amount int) Money {
+	return Money(amount * 10)
+}
+
+// InRials returns amount of money in Rials
+func (m Money) InRials() int {
+	return int(m)
+}
+
+// InTomans returns amount of money in Tomans, Rials which are less than a
+// Toman are dropped
+func (m Money) InTomans() int {
+	return int(m) / 10
+}
+
+// Times returns count times of money, like price of count portions
+func (m Money) Times(count int) Money {
+	return m * Money(count)
+}
+
+// FormatRials formats money in Rials with Persian digits and thousands
+// separators, like ۱۲٬۵۰۰
+func (m Money) FormatRials() string {
+	return formatPersianNumber(m < 0, absInt(int(m)), 0)
+}
+
+// FormatTomans formats money in Tomans with Persian digits and thousands
+// separators, Rials which are less than a Toman are shown as a decimal,
+// like ۱٬۲۵۰٫۵
+func (m Money) FormatTomans() string {
+	rials := absInt(int(m))
+	return formatPersianNumber(m < 0, rials/10, rials%10)
+}
+
+// formatPersianNumber formats a number whose integer part is whole and has
+// a single decimal digit, decimal is dropped if it's zero
+func formatPersianNumber(negative bool, whole, decimal int) string {
+	digits := strconv.Itoa(whole)
+	var formatted []string
+	for len(digits) > 3 {
+		formatted = append([]string{digits[len(digits)-3:]}, formatted...)
+		digits = digits[:len(digits)-3]
+	}
+	formatted = append([]string{digits}, formatted...)
+	result := strings.Join(formatted, persianThousandSeparator)
+	if decimal != 0 {
+		result += persianDecimalSeparator + strconv.Itoa(decimal)
+	}
+	if negative {
+		result = "-" + result
+	}
+	return toPersianDigits(result)
+}
+
+// toPersianDigits replaces ASCII digits of s with Persian ones
+func toPersianDigits(s string) string {
+	persian := []rune(persianDigits)
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return persian[r-'0']
+		}
+		return r
+	}, s)
+}
+
+func absInt(number int) int {
+	if number < 0 {
+		return -number
+	}
+	return number
+}
@@ -0,0 +1,37 @@
+package model
+
+import "testing"
+
+func TestMoneyConversion(t *testing.T) {
+	if Tomans(1250) != Rials(12500) {
+		t.Errorf("Tomans(1250) = %v Rials, want 12500", Tomans(1250).InRials())
+	}
+	if Rials(12505).InTomans() != 1250 {
+		t.Errorf("InTomans() = %v, want 1250", Rials(12505).InTomans())
+	}
+	if Rials(12500).Times(3) != Rials(37500) {
+		t.Errorf("Times(3) = %v, want 37500", Rials(12500).Times(3))
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		money  Money
+		rials  string
+		tomans string
+	}{
+		{Rials(0), "۰", "۰"},
+		{Rials(12500), "۱۲٬۵۰۰", "۱٬۲۵۰"},
+		{Rials(1234567890), "۱٬۲۳۴٬۵۶۷٬۸۹۰", "۱۲۳٬۴۵۶٬۷۸۹"},
+		{Rials(-24549), "-۲۴٬۵۴۹", "-۲٬۴۵۴٫۹"},
+		{Rials(-5), "-۵", "-۰٫۵"},
+	}
+	for _, test := range tests {
+		if rials := test.money.FormatRials(); rials != test.rials {
+			t.Errorf("FormatRials() of %d = %v, want %v", test.money, rials, test.rials)
+		}
+		if tomans := test.money.FormatTomans(); tomans != test.tomans {
+			t.Errorf("FormatTomans() of %d = %v, want %v", test.money, tomans, test.tomans)
+		}
+	}
+}
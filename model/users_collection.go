@@ -8,4 +8,5 @@ type User struct {
 	ReservationService string
 	StudentID          string
 	Password           string
+	DefaultSelfID      string
 }
@@ -0,0 +1,227 @@
+package selfservice
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/spf13/viper"
+)
+
+// CaptchaSolver extracts text of a captcha image
+type CaptchaSolver interface {
+	Solve(captcha image.Image) (string, error)
+}
+
+// CaptchaSolverConfig contains settings of captcha solvers
+type CaptchaSolverConfig struct {
+	// Preprocessing is applied to captcha images before recognition
+	Preprocessing CaptchaPreprocessing
+	// SamplesDir is directory of labeled captcha images which is used by
+	// solvers that need training
+	SamplesDir string
+}
+
+// LoadCaptchaSolverConfig reads settings of captcha solvers from samad
+// section of config
+func LoadCaptchaSolverConfig(config *viper.Viper) CaptchaSolverConfig {
+	return CaptchaSolverConfig{
+		Preprocessing: CaptchaPreprocessing{
+			Threshold:     uint8(config.GetInt("samad.captcha-preprocessing.threshold")),
+			MinNeighbours: config.GetInt("samad.captcha-preprocessing.min-neighbours"),
+			Margin:        config.GetInt("samad.captcha-preprocessing.margin"),
+		},
+		SamplesDir: config.GetString("samad.captcha-samples-dir"),
+	}
+}
+
+type captchaSolverFactory func(config CaptchaSolverConfig) (CaptchaSolver, error)
+
+var captchaSolverFactories = map[string]captchaSolverFactory{}
+
+// NewCaptchaSolver creates the captcha solver which is registered by name
+func NewCaptchaSolver(name string, config CaptchaSolverConfig) (CaptchaSolver, error) {
+	factory, ok := captchaSolverFactories[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown captcha solver %q, available solvers are %v",
+			name, CaptchaSolverNames())
+	}
+	solver, err := factory(config)
+	if err != nil {
+		// Solver of a failed factory isn't a nil interface, callers which
+		// go on without a solver should get nil
+		return nil, err
+	}
+	return solver, nil
+}
+
+// CaptchaSolverNames returns names of all available captcha solvers
+func CaptchaSolverNames() []string {
+	var names []string
+	for name := range captchaSolverFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CaptchaPreprocessing contains settings of cleaning captcha images up
+// before recognition
+type CaptchaPreprocessing struct {
+	// Threshold is the gray level under which a pixel is counted as text,
+	// zero means it's computed for each image using Otsu's method
+	Threshold uint8
+	// MinNeighbours is the least number of text neighbours a text pixel needs
+	// to not be removed as part of a noise line, zero disables noise removal
+	MinNeighbours int
+	// Margin is the number of blank pixels which are kept around the text
+	// when the image is cropped
+	Margin int
+}
+
+// Apply converts captcha to a black and white image, removes its noise lines
+// and crops it to the text
+func (p CaptchaPreprocessing) Apply(captcha image.Image) *image.Gray {
+	gray := toGray(captcha)
+
+	threshold := p.Threshold
+	if threshold == 0 {
+		threshold = otsuThreshold(gray)
+	}
+	binary := binarize(gray, threshold)
+	if p.MinNeighbours > 0 {
+		binary = removeNoise(binary, p.MinNeighbours)
+	}
+	return cropToInk(binary, p.Margin)
+}
+
+func toGray(img image.Image) *image.Gray {
+	bounds := img.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			gray.Set(x-bounds.Min.X, y-bounds.Min.Y, color.GrayModel.Convert(img.At(x, y)))
+		}
+	}
+	return gray
+}
+
+// otsuThreshold finds the gray level which best separates text from
+// background of image
+func otsuThreshold(gray *image.Gray) uint8 {
+	var histogram [256]int
+	for _, pixel := range gray.Pix {
+		histogram[pixel]++
+	}
+
+	total := len(gray.Pix)
+	var sum float64
+	for level, count := range histogram {
+		sum += float64(level * count)
+	}
+
+	var (
+		backgroundSum    float64
+		backgroundWeight int
+		bestVariance     float64
+		threshold        uint8
+	)
+	for level, count := range histogram {
+		backgroundWeight += count
+		if backgroundWeight == 0 {
+			continue
+		}
+		foregroundWeight := total - backgroundWeight
+		if foregroundWeight == 0 {
+			break
+		}
+
+		backgroundSum += float64(level * count)
+		backgroundMean := backgroundSum / float64(backgroundWeight)
+		foregroundMean := (sum - backgroundSum) / float64(foregroundWeight)
+		variance := float64(backgroundWeight) * float64(foregroundWeight) *
+			(backgroundMean - foregroundMean) * (backgroundMean - foregroundMean)
+		if variance > bestVariance {
+			bestVariance = variance
+			threshold = uint8(level + 1)
+		}
+	}
+	return threshold
+}
+
+// binarize makes pixels darker than threshold black and others white
+func binarize(gray *image.Gray, threshold uint8) *image.Gray {
+	binary := image.NewGray(gray.Bounds())
+	for i, pixel := range gray.Pix {
+		if pixel < threshold {
+			binary.Pix[i] = 0
+		} else {
+			binary.Pix[i] = 255
+		}
+	}
+	return binary
+}
+
+func isInk(binary *image.Gray, x, y int) bool {
+	if !(image.Point{x, y}.In(binary.Bounds())) {
+		return false
+	}
+	return binary.GrayAt(x, y).Y == 0
+}
+
+// removeNoise removes thin lines by whitening black pixels which have less
+// than minNeighbours black neighbours
+func removeNoise(binary *image.Gray, minNeighbours int) *image.Gray {
+	bounds := binary.Bounds()
+	cleaned := image.NewGray(bounds)
+	copy(cleaned.Pix, binary.Pix)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if !isInk(binary, x, y) {
+				continue
+			}
+
+			var neighbours int
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && isInk(binary, x+dx, y+dy) {
+						neighbours++
+					}
+				}
+			}
+			if neighbours < minNeighbours {
+				cleaned.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return cleaned
+}
+
+// cropToInk crops image to the smallest rectangle which contains all of black
+// pixels plus margin
+func cropToInk(binary *image.Gray, margin int) *image.Gray {
+	bounds := binary.Bounds()
+	ink := image.Rectangle{}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if isInk(binary, x, y) {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	if ink.Empty() {
+		return binary
+	}
+
+	ink = image.Rect(ink.Min.X-margin, ink.Min.Y-margin, ink.Max.X+margin, ink.Max.Y+margin).
+		Intersect(bounds)
+	cropped := image.NewGray(image.Rect(0, 0, ink.Dx(), ink.Dy()))
+	for y := ink.Min.Y; y < ink.Max.Y; y++ {
+		for x := ink.Min.X; x < ink.Max.X; x++ {
+			cropped.SetGray(x-ink.Min.X, y-ink.Min.Y, binary.GrayAt(x, y))
+		}
+	}
+	return cropped
+}
@@ -0,0 +1,225 @@
+package selfservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png" // labeled samples may be saved as PNG
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// unverifiedCaptchasDir is the subdirectory of dataset which keeps
+	// captchas whose guess hasn't been accepted by Samad
+	unverifiedCaptchasDir = "unverified"
+	captchaDatasetIndex   = "index.csv"
+)
+
+// LabeledCaptcha is a captcha image whose text is known. Name of a labeled
+// captcha file starts with its text, e.g. 4821.jpg or 4821_2.png.
+type LabeledCaptcha struct {
+	FileName string
+	Label    string
+	Image    image.Image
+}
+
+// CaptchaSample is a captcha of Samad's login form with result of solving it
+type CaptchaSample struct {
+	// Image is the JPEG image of captcha
+	Image    []byte
+	Guess    string
+	LoggedIn bool
+}
+
+// CaptchaBenchmark is the result of running a captcha solver on a labeled
+// dataset
+type CaptchaBenchmark struct {
+	Samples   int
+	Correct   int
+	Failures  int
+	TotalTime time.Duration
+}
+
+// CaptchaLabel extracts text of a labeled captcha image from its file name
+func CaptchaLabel(fileName string) string {
+	label := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	if i := strings.Index(label, "_"); i >= 0 {
+		label = label[:i]
+	}
+	return label
+}
+
+func isImageFile(fileName string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
+
+// ReadCaptchaDataset reads all labeled captcha images of dir, files which
+// can't be decoded are skipped
+func ReadCaptchaDataset(dir string) ([]*LabeledCaptcha, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't read captcha dataset directory")
+	}
+
+	var captchas []*LabeledCaptcha
+	for _, file := range files {
+		if file.IsDir() || !isImageFile(file.Name()) {
+			continue
+		}
+		label := CaptchaLabel(file.Name())
+		if len(label) == 0 {
+			continue
+		}
+
+		captcha, err := readImageFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			logrus.WithError(err).WithField("file", file.Name()).Warnln("can't read captcha sample")
+			continue
+		}
+		captchas = append(captchas, &LabeledCaptcha{
+			FileName: file.Name(),
+			Label:    label,
+			Image:    captcha,
+		})
+	}
+	return captchas, nil
+}
+
+func readImageFile(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	return img, err
+}
+
+// CollectCaptchaSample gets a captcha from Samad of provider, solves it using
+// solver and tries to log in with the guess to find out if it's correct.
+// Captchas aren't guessed if solver is nil.
+func CollectCaptchaSample(ctx context.Context, provider SamadProvider, username, password string,
+	solver CaptchaSolver) (*CaptchaSample, error) {
+	client, err := newSamadClient(provider, username, password,
+		SamadConfig{CaptchaSolver: solver, ManualCaptcha: solver == nil})
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := client.newLoginChallenge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sample := &CaptchaSample{Image: challenge.CaptchaImage}
+
+	captcha, err := jpeg.Decode(bytes.NewReader(challenge.CaptchaImage))
+	if err != nil {
+		return nil, errors.Wrap(err, "can't decode captcha")
+	}
+	if solver == nil {
+		return sample, nil
+	}
+	sample.Guess, err = solver.Solve(captcha)
+	if err != nil || len(sample.Guess) == 0 {
+		return sample, nil
+	}
+
+	err = client.login(ctx, sample.Guess)
+	switch errors.Cause(err) {
+	case nil:
+		sample.LoggedIn = true
+	case ErrWrongCaptcha:
+	default:
+		return nil, err
+	}
+	return sample, nil
+}
+
+// SaveCaptchaSample saves sample in dataset dir. Captchas whose guess has
+// been accepted by Samad are saved as labeled captchas, others are saved in
+// unverified subdirectory to be labeled by hand. All samples are recorded in
+// index.csv of dir.
+func SaveCaptchaSample(dir string, sample *CaptchaSample) (string, error) {
+	sampleDir := dir
+	if !sample.LoggedIn {
+		sampleDir = filepath.Join(dir, unverifiedCaptchasDir)
+	}
+	if err := os.MkdirAll(sampleDir, 0755); err != nil {
+		return "", errors.Wrap(err, "can't create dataset directory")
+	}
+
+	guess := sample.Guess
+	if len(guess) == 0 || strings.ContainsAny(guess, "_./\\") {
+		guess = "unknown"
+	}
+	fileName := fmt.Sprintf("%s_%d.jpg", guess, time.Now().UnixNano())
+	path := filepath.Join(sampleDir, fileName)
+	if err := ioutil.WriteFile(path, sample.Image, 0644); err != nil {
+		return "", errors.Wrap(err, "can't save captcha")
+	}
+
+	index, err := os.OpenFile(filepath.Join(dir, captchaDatasetIndex),
+		os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", errors.Wrap(err, "can't open dataset index")
+	}
+	defer index.Close()
+	writer := csv.NewWriter(index)
+	relativePath, _ := filepath.Rel(dir, path)
+	writer.Write([]string{relativePath, sample.Guess, strconv.FormatBool(sample.LoggedIn)})
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", errors.Wrap(err, "can't write dataset index")
+	}
+
+	return path, nil
+}
+
+// BenchmarkCaptchaSolver runs solver on all of the labeled captchas
+func BenchmarkCaptchaSolver(solver CaptchaSolver, captchas []*LabeledCaptcha) *CaptchaBenchmark {
+	benchmark := &CaptchaBenchmark{}
+	for _, captcha := range captchas {
+		startTime := time.Now()
+		guess, err := solver.Solve(captcha.Image)
+		benchmark.TotalTime += time.Since(startTime)
+
+		benchmark.Samples++
+		if err != nil {
+			benchmark.Failures++
+		} else if guess == captcha.Label {
+			benchmark.Correct++
+		}
+	}
+	return benchmark
+}
+
+// Accuracy returns ratio of correctly solved captchas
+func (b *CaptchaBenchmark) Accuracy() float64 {
+	if b.Samples == 0 {
+		return 0
+	}
+	return float64(b.Correct) / float64(b.Samples)
+}
+
+// MeanLatency returns average time of solving a captcha
+func (b *CaptchaBenchmark) MeanLatency() time.Duration {
+	if b.Samples == 0 {
+		return 0
+	}
+	return b.TotalTime / time.Duration(b.Samples)
+}
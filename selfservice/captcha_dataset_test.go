@@ -0,0 +1,93 @@
+package selfservice
+
+import (
+	"bytes"
+	"context"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aryahadii/sarioself/test/fakesamad"
+)
+
+func TestCaptchaLabel(t *testing.T) {
+	for fileName, label := range map[string]string{
+		"4821.jpg":   "4821",
+		"4821_2.png": "4821",
+		"4821":       "4821",
+	} {
+		if CaptchaLabel(fileName) != label {
+			t.Errorf("CaptchaLabel(%v) returned %v instead of %v", fileName, CaptchaLabel(fileName), label)
+		}
+	}
+}
+
+func TestCaptchaDataset(t *testing.T) {
+	datasetDir, err := ioutil.TempDir("", "captcha-dataset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datasetDir)
+
+	for _, sample := range []struct {
+		text     string
+		guess    string
+		loggedIn bool
+	}{
+		{"107", "107", true},
+		{"701", "701", true},
+		{"710", "11", false},
+	} {
+		image := &bytes.Buffer{}
+		jpeg.Encode(image, makeTestCaptcha(sample.text), &jpeg.Options{Quality: 100})
+		_, err := SaveCaptchaSample(datasetDir, &CaptchaSample{
+			Image:    image.Bytes(),
+			Guess:    sample.guess,
+			LoggedIn: sample.loggedIn,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	captchas, err := ReadCaptchaDataset(datasetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(captchas) != 2 {
+		t.Fatalf("ReadCaptchaDataset() returned %v captchas instead of 2", len(captchas))
+	}
+	unverified, _ := ReadCaptchaDataset(filepath.Join(datasetDir, unverifiedCaptchasDir))
+	if len(unverified) != 1 || unverified[0].Label != "11" {
+		t.Errorf("SaveCaptchaSample() didn't save unverified captcha correctly")
+	}
+
+	solver, err := NewTemplateCaptchaSolver(CaptchaPreprocessing{MinNeighbours: 2}, datasetDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	benchmark := BenchmarkCaptchaSolver(solver, captchas)
+	if benchmark.Samples != 2 || benchmark.Accuracy() != 1 {
+		t.Errorf("BenchmarkCaptchaSolver() returned %+v", benchmark)
+	}
+}
+
+func TestCollectCaptchaSample(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	sample, err := CollectCaptchaSample(ctx, provider, fakeSamadUsername, fakeSamadPassword,
+		fixedCaptchaSolver(fakesamad.CaptchaAnswer))
+	if err != nil || sample.Guess != fakesamad.CaptchaAnswer || !sample.LoggedIn {
+		t.Errorf("CollectCaptchaSample() = %+v, %v", sample, err)
+	}
+
+	// The first samples are collected without solver
+	sample, err = CollectCaptchaSample(ctx, provider, fakeSamadUsername, fakeSamadPassword, nil)
+	if err != nil || len(sample.Image) == 0 || len(sample.Guess) > 0 || sample.LoggedIn {
+		t.Errorf("CollectCaptchaSample() without solver = %+v, %v", sample, err)
+	}
+}
@@ -0,0 +1,265 @@
+package selfservice
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	glyphWidth  = 12
+	glyphHeight = 16
+
+	// minGlyphInkRatio is the least ink a segment needs, relative to the
+	// biggest segment of captcha, to be counted as a glyph instead of noise
+	minGlyphInkRatio = 0.15
+
+	// captchaSamplesHelp tells how samples of template solver are made, they
+	// aren't shipped since each Samad instance has its own captchas
+	captchaSamplesHelp = "collect captchas with `sarioself captcha collect`, rename the ones in " +
+		"unverified directory of dataset to their text and copy them to samad.captcha-samples-dir"
+)
+
+func init() {
+	captchaSolverFactories["template"] = func(config CaptchaSolverConfig) (CaptchaSolver, error) {
+		return NewTemplateCaptchaSolver(config.Preprocessing, config.SamplesDir)
+	}
+}
+
+// TemplateCaptchaSolver solves captchas by matching each of their glyphs
+// against templates which are learned from labeled captcha images
+type TemplateCaptchaSolver struct {
+	preprocessing CaptchaPreprocessing
+	templates     map[rune]*glyphTemplate
+	// lengths counts learned captchas by length of their text, the most
+	// common one is used to split touching glyphs of solved captchas
+	lengths map[int]int
+}
+
+// glyphTemplate is the average of all learned glyphs of a character
+type glyphTemplate struct {
+	sum     []float64
+	samples int
+}
+
+// NewTemplateCaptchaSolver creates new instance of TemplateCaptchaSolver and
+// learns templates from labeled captcha images in samplesDir
+func NewTemplateCaptchaSolver(preprocessing CaptchaPreprocessing, samplesDir string) (*TemplateCaptchaSolver, error) {
+	solver := &TemplateCaptchaSolver{
+		preprocessing: preprocessing,
+		templates:     make(map[rune]*glyphTemplate),
+		lengths:       make(map[int]int),
+	}
+	if len(samplesDir) == 0 {
+		return nil, fmt.Errorf("samples directory of template captcha solver isn't set, %s", captchaSamplesHelp)
+	}
+
+	samples, err := ReadCaptchaDataset(samplesDir)
+	if err != nil {
+		return nil, fmt.Errorf("template captcha solver has no samples, %v; %s", err, captchaSamplesHelp)
+	}
+	for _, sample := range samples {
+		if err := solver.Learn(sample.Image, sample.Label); err != nil {
+			logrus.WithError(err).WithField("file", sample.FileName).Warnln("can't learn captcha sample")
+		}
+	}
+	if len(solver.templates) == 0 {
+		return nil, fmt.Errorf("no captcha sample is learned from %v, %s", samplesDir, captchaSamplesHelp)
+	}
+
+	return solver, nil
+}
+
+// Learn adds glyphs of a captcha with known text to templates
+func (t *TemplateCaptchaSolver) Learn(captcha image.Image, label string) error {
+	characters := []rune(label)
+	glyphs := segmentGlyphs(t.preprocessing.Apply(captcha), len(characters))
+	if len(glyphs) != len(characters) {
+		return fmt.Errorf("found %v glyphs in captcha of %q", len(glyphs), label)
+	}
+
+	for i, character := range characters {
+		template, ok := t.templates[character]
+		if !ok {
+			template = &glyphTemplate{sum: make([]float64, glyphWidth*glyphHeight)}
+			t.templates[character] = template
+		}
+		for j, value := range glyphs[i] {
+			template.sum[j] += value
+		}
+		template.samples++
+	}
+	t.lengths[len(characters)]++
+	return nil
+}
+
+// Solve reads text of captcha by finding the nearest template of each glyph
+func (t *TemplateCaptchaSolver) Solve(captcha image.Image) (string, error) {
+	glyphs := segmentGlyphs(t.preprocessing.Apply(captcha), t.captchaLength())
+	if len(glyphs) == 0 {
+		return "", fmt.Errorf("can't find any glyph in captcha")
+	}
+
+	text := make([]rune, 0, len(glyphs))
+	for _, glyph := range glyphs {
+		var (
+			best         rune
+			bestDistance = math.Inf(1)
+		)
+		for character, template := range t.templates {
+			if distance := template.distance(glyph); distance < bestDistance {
+				best, bestDistance = character, distance
+			}
+		}
+		text = append(text, best)
+	}
+	return string(text), nil
+}
+
+// captchaLength returns the most common length of learned captchas
+func (t *TemplateCaptchaSolver) captchaLength() int {
+	length := 0
+	for l, count := range t.lengths {
+		if count > t.lengths[length] || (count == t.lengths[length] && l > length) {
+			length = l
+		}
+	}
+	return length
+}
+
+func (g *glyphTemplate) distance(glyph []float64) float64 {
+	var distance float64
+	for i, value := range glyph {
+		distance += math.Abs(g.sum[i]/float64(g.samples) - value)
+	}
+	return distance
+}
+
+// segmentGlyphs splits a preprocessed captcha into glyphs at blank columns
+// and scales each of them to glyphWidth x glyphHeight. If count is positive,
+// the widest glyphs are split until there are count glyphs.
+func segmentGlyphs(binary *image.Gray, count int) [][]float64 {
+	bounds := binary.Bounds()
+
+	// Find runs of columns which contain ink
+	var segments []image.Rectangle
+	start := -1
+	for x := bounds.Min.X; x <= bounds.Max.X; x++ {
+		hasInk := false
+		for y := bounds.Min.Y; y < bounds.Max.Y && x < bounds.Max.X; y++ {
+			if isInk(binary, x, y) {
+				hasInk = true
+				break
+			}
+		}
+		if hasInk && start < 0 {
+			start = x
+		} else if !hasInk && start >= 0 {
+			segments = append(segments, image.Rect(start, bounds.Min.Y, x, bounds.Max.Y))
+			start = -1
+		}
+	}
+
+	// Drop specks which have survived noise removal
+	inks := make([]int, len(segments))
+	maxInk := 0
+	for i, segment := range segments {
+		inks[i] = countInk(binary, segment)
+		if inks[i] > maxInk {
+			maxInk = inks[i]
+		}
+	}
+	var glyphs []image.Rectangle
+	for i, segment := range segments {
+		if float64(inks[i]) >= minGlyphInkRatio*float64(maxInk) {
+			glyphs = append(glyphs, segment)
+		}
+	}
+
+	// Split touching glyphs, each segment is split into equal parts and
+	// segments whose parts are the widest get more parts
+	if count > len(glyphs) && len(glyphs) > 0 {
+		parts := make([]int, len(glyphs))
+		for i := range parts {
+			parts[i] = 1
+		}
+		for n := len(glyphs); n < count; n++ {
+			widest := 0
+			for i, glyph := range glyphs {
+				if glyph.Dx()*parts[widest] > glyphs[widest].Dx()*parts[i] {
+					widest = i
+				}
+			}
+			if glyphs[widest].Dx() <= parts[widest] {
+				break
+			}
+			parts[widest]++
+		}
+
+		var split []image.Rectangle
+		for i, glyph := range glyphs {
+			for j := 0; j < parts[i]; j++ {
+				split = append(split, image.Rect(
+					glyph.Min.X+j*glyph.Dx()/parts[i], glyph.Min.Y,
+					glyph.Min.X+(j+1)*glyph.Dx()/parts[i], glyph.Max.Y))
+			}
+		}
+		glyphs = split
+	}
+
+	normalized := make([][]float64, len(glyphs))
+	for i, glyph := range glyphs {
+		normalized[i] = normalizeGlyph(binary, glyph)
+	}
+	return normalized
+}
+
+func countInk(binary *image.Gray, rect image.Rectangle) int {
+	var ink int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if isInk(binary, x, y) {
+				ink++
+			}
+		}
+	}
+	return ink
+}
+
+// normalizeGlyph crops glyph to its ink and scales it down to a
+// glyphWidth x glyphHeight grid of ink ratios
+func normalizeGlyph(binary *image.Gray, glyph image.Rectangle) []float64 {
+	cropped := image.Rectangle{}
+	for y := glyph.Min.Y; y < glyph.Max.Y; y++ {
+		for x := glyph.Min.X; x < glyph.Max.X; x++ {
+			if isInk(binary, x, y) {
+				cropped = cropped.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	cells := make([]float64, glyphWidth*glyphHeight)
+	if cropped.Empty() {
+		return cells
+	}
+	for row := 0; row < glyphHeight; row++ {
+		minY := cropped.Min.Y + row*cropped.Dy()/glyphHeight
+		maxY := cropped.Min.Y + (row+1)*cropped.Dy()/glyphHeight
+		if maxY == minY {
+			maxY++
+		}
+		for column := 0; column < glyphWidth; column++ {
+			minX := cropped.Min.X + column*cropped.Dx()/glyphWidth
+			maxX := cropped.Min.X + (column+1)*cropped.Dx()/glyphWidth
+			if maxX == minX {
+				maxX++
+			}
+			cell := image.Rect(minX, minY, maxX, maxY)
+			cells[row*glyphWidth+column] = float64(countInk(binary, cell)) /
+				float64(cell.Dx()*cell.Dy())
+		}
+	}
+	return cells
+}
@@ -0,0 +1,112 @@
+package selfservice
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testGlyphs = map[rune][]string{
+	'1': {
+		"..##..",
+		".###..",
+		"..##..",
+		"..##..",
+		"..##..",
+		".####.",
+	},
+	'0': {
+		".####.",
+		"##..##",
+		"##..##",
+		"##..##",
+		"##..##",
+		".####.",
+	},
+	'7': {
+		"######",
+		"....##",
+		"...##.",
+		"..##..",
+		".##...",
+		".##...",
+	},
+}
+
+// makeTestCaptcha draws text using testGlyphs, each glyph pixel is 3x3
+func makeTestCaptcha(text string) image.Image {
+	return drawTestCaptcha(text, 9)
+}
+
+// drawTestCaptcha draws text with glyphs which are advance glyph pixels
+// apart, glyphs touch each other if advance is 6
+func drawTestCaptcha(text string, advance int) image.Image {
+	const scale = 3
+	captcha := image.NewRGBA(image.Rect(0, 0, 10+len(text)*advance*scale, 30))
+	for y := 0; y < captcha.Bounds().Dy(); y++ {
+		for x := 0; x < captcha.Bounds().Dx(); x++ {
+			captcha.Set(x, y, color.RGBA{230, 230, 210, 255})
+		}
+	}
+	for i, character := range text {
+		for row, line := range testGlyphs[character] {
+			for column, pixel := range line {
+				if pixel != '#' {
+					continue
+				}
+				for dy := 0; dy < scale; dy++ {
+					for dx := 0; dx < scale; dx++ {
+						captcha.Set(5+i*advance*scale+column*scale+dx, 5+row*scale+dy, color.RGBA{20, 20, 60, 255})
+					}
+				}
+			}
+		}
+	}
+	return captcha
+}
+
+func TestTemplateCaptchaSolver(t *testing.T) {
+	samplesDir, err := ioutil.TempDir("", "captcha-samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(samplesDir)
+
+	for _, label := range []string{"107", "710_2"} {
+		file, err := os.Create(filepath.Join(samplesDir, label+".png"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(file, makeTestCaptcha(CaptchaLabel(label)))
+		file.Close()
+	}
+
+	solver, err := NewTemplateCaptchaSolver(CaptchaPreprocessing{MinNeighbours: 2}, samplesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{"017", "777", "100"} {
+		solved, err := solver.Solve(makeTestCaptcha(text))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if solved != text {
+			t.Errorf("Solve() returned %v instead of %v", solved, text)
+		}
+	}
+
+	// Glyphs of Samad's captchas may touch each other
+	for _, text := range []string{"070", "700"} {
+		solved, err := solver.Solve(drawTestCaptcha(text, 6))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if solved != text {
+			t.Errorf("Solve() returned %v instead of %v for touching glyphs", solved, text)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+//go:build tesseract
+// +build tesseract
+
+package selfservice
+
+import (
+	"image"
+	"strings"
+	"sync"
+
+	"github.com/otiai10/gosseract/v1/gosseract"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	captchaSolverFactories["tesseract"] = func(config CaptchaSolverConfig) (CaptchaSolver, error) {
+		return NewTesseractCaptchaSolver(config.Preprocessing)
+	}
+}
+
+// TesseractCaptchaSolver solves captchas using Tesseract OCR, it's only built
+// with tesseract build tag since gosseract needs cgo and Tesseract headers
+type TesseractCaptchaSolver struct {
+	lock          sync.Mutex
+	ocrClient     *gosseract.Client
+	preprocessing CaptchaPreprocessing
+}
+
+// NewTesseractCaptchaSolver creates new instance of TesseractCaptchaSolver,
+// it fails if Tesseract isn't installed
+func NewTesseractCaptchaSolver(preprocessing CaptchaPreprocessing) (*TesseractCaptchaSolver, error) {
+	ocrClient, err := gosseract.NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "can't init gosseract client")
+	}
+	return &TesseractCaptchaSolver{
+		ocrClient:     ocrClient,
+		preprocessing: preprocessing,
+	}, nil
+}
+
+// Solve reads text of captcha using OCR
+func (t *TesseractCaptchaSolver) Solve(captcha image.Image) (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	text, err := t.ocrClient.Image(t.preprocessing.Apply(captcha)).Out()
+	if err != nil {
+		return "", errors.Wrap(err, "can't run OCR on captcha")
+	}
+	return strings.TrimSpace(text), nil
+}
@@ -0,0 +1,51 @@
+package selfservice
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestCaptchaPreprocessing(t *testing.T) {
+	captcha := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			captcha.Set(x, y, color.RGBA{220, 220, 200, 255})
+		}
+	}
+	// A 4x6 glyph
+	for y := 8; y < 14; y++ {
+		for x := 10; x < 14; x++ {
+			captcha.Set(x, y, color.RGBA{30, 30, 60, 255})
+		}
+	}
+	// A one pixel noise line
+	for x := 0; x < 40; x += 2 {
+		captcha.Set(x, 2, color.RGBA{40, 40, 40, 255})
+	}
+
+	preprocessing := CaptchaPreprocessing{MinNeighbours: 2, Margin: 1}
+	processed := preprocessing.Apply(captcha)
+
+	if processed.Bounds().Dx() != 6 || processed.Bounds().Dy() != 8 {
+		t.Fatalf("Apply() cropped captcha to %v instead of 6x8", processed.Bounds())
+	}
+	if processed.GrayAt(0, 0).Y != 255 || processed.GrayAt(1, 1).Y != 0 {
+		t.Errorf("Apply() didn't binarize captcha")
+	}
+}
+
+func TestNewCaptchaSolver(t *testing.T) {
+	if _, err := NewCaptchaSolver("unknown", CaptchaSolverConfig{}); err == nil {
+		t.Error("NewCaptchaSolver() created an unknown solver")
+	}
+
+	solver, err := NewCaptchaSolver("template", CaptchaSolverConfig{SamplesDir: "not-existing"})
+	if err == nil || solver != nil {
+		t.Fatalf("NewCaptchaSolver() without samples = %v, %v", solver, err)
+	}
+	if !strings.Contains(err.Error(), "sarioself captcha collect") {
+		t.Errorf("error of missing samples doesn't tell how to collect them, %v", err)
+	}
+}
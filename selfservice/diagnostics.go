@@ -0,0 +1,208 @@
+package selfservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+)
+
+const (
+	redacted = "[REDACTED]"
+
+	// studentNameSelector is the element next to student's name and ID in
+	// header of Samad pages
+	studentNameSelector = "#the_time"
+)
+
+var csrfHeaderRegex = regexp.MustCompile(`('X-CSRF-TOKEN'\s*:\s*')[^']*'`)
+
+// ParseFailure is a Samad page which couldn't be parsed completely
+type ParseFailure struct {
+	Provider string
+	Time     time.Time
+	// Errors are failed fields of page
+	Errors []ParseError
+	// Path is where redacted page is saved, it's empty if page isn't saved
+	// since the same failure has been captured recently
+	Path string
+	// Suppressed is number of failures which haven't been alerted since
+	// the previous alert
+	Suppressed int
+}
+
+// Diagnostics saves Samad pages which can't be parsed, so changes of Samad's
+// layout can be debugged, and alerts admins about them
+type Diagnostics struct {
+	lock     sync.Mutex
+	dir      string
+	interval time.Duration
+	alert    func(failure *ParseFailure)
+
+	lastAlert   time.Time
+	suppressed  int
+	lastCapture map[string]time.Time
+	// captures is number of saved pages, it keeps names of files unique
+	captures int
+}
+
+// NewDiagnostics creates new instance of Diagnostics which saves pages in
+// dir. Each failure is saved and alerted at most once per interval, alert
+// can be nil.
+func NewDiagnostics(dir string, interval time.Duration, alert func(failure *ParseFailure)) *Diagnostics {
+	return &Diagnostics{
+		dir:         dir,
+		interval:    interval,
+		alert:       alert,
+		lastCapture: make(map[string]time.Time),
+	}
+}
+
+// CaptureParseFailure redacts page and saves it along with the fields which
+// have failed. secrets are removed from page before it's written.
+func (d *Diagnostics) CaptureParseFailure(provider, page string, parseErrors []error, secrets ...string) error {
+	failure := &ParseFailure{
+		Provider: provider,
+		Time:     time.Now(),
+	}
+	for _, err := range parseErrors {
+		if parseErr, ok := errors.Cause(err).(ParseError); ok {
+			failure.Errors = append(failure.Errors, parseErr)
+		}
+	}
+	if len(failure.Errors) == 0 {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	signature := failure.signature()
+	if time.Since(d.lastCapture[signature]) >= d.interval {
+		d.lastCapture[signature] = failure.Time
+		path, err := d.save(failure, redactPage(page, secrets))
+		if err != nil {
+			return err
+		}
+		failure.Path = path
+	}
+
+	if d.alert == nil {
+		return nil
+	}
+	if time.Since(d.lastAlert) < d.interval {
+		d.suppressed++
+		return nil
+	}
+	failure.Suppressed = d.suppressed
+	d.lastAlert = failure.Time
+	d.suppressed = 0
+	go d.alert(failure)
+	return nil
+}
+
+// signature identifies failures of the same fields of a provider
+func (f *ParseFailure) signature() string {
+	var fields []string
+	for _, err := range f.Errors {
+		fields = append(fields, err.Field)
+	}
+	sort.Strings(fields)
+	return f.Provider + "#" + strings.Join(fields, ",")
+}
+
+// save writes page and a JSON report of failed fields next to it
+func (d *Diagnostics) save(failure *ParseFailure, page string) (string, error) {
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return "", errors.Wrap(err, "can't create diagnostics directory")
+	}
+	d.captures++
+	name := fmt.Sprintf("%s-%s-%d", failure.Time.Format("20060102-150405.000"), failure.Provider, d.captures)
+	path := filepath.Join(d.dir, name+".html")
+	if err := ioutil.WriteFile(path, []byte(page), 0600); err != nil {
+		return "", errors.Wrap(err, "can't save page")
+	}
+
+	type failedField struct {
+		Field    string `json:"field"`
+		Selector string `json:"selector"`
+		Value    string `json:"value,omitempty"`
+		Error    string `json:"error"`
+	}
+	report := struct {
+		Provider string        `json:"provider"`
+		Time     time.Time     `json:"time"`
+		Fields   []failedField `json:"fields"`
+	}{
+		Provider: failure.Provider,
+		Time:     failure.Time,
+	}
+	for _, err := range failure.Errors {
+		report.Fields = append(report.Fields, failedField{
+			Field:    err.Field,
+			Selector: err.Selector,
+			Value:    err.Value,
+			Error:    err.Error(),
+		})
+	}
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(d.dir, name+".json"), reportBytes, 0600); err != nil {
+		return "", errors.Wrap(err, "can't save report of page")
+	}
+	return path, nil
+}
+
+// redactPage removes CSRF tokens, student's name and secrets from page
+func redactPage(page string, secrets []string) string {
+	if document, err := goquery.NewDocumentFromReader(strings.NewReader(page)); err == nil {
+		document.Find(studentNameSelector).Parent().Contents().Each(func(i int, s *goquery.Selection) {
+			if node := s.Get(0); node.Type == html.TextNode && len(strings.TrimSpace(node.Data)) > 0 {
+				node.Data = redacted
+			}
+		})
+		document.Find("input[name=_csrf], input[name=username], input[name=password]").
+			SetAttr("value", redacted)
+		if redactedPage, err := document.Html(); err == nil {
+			page = redactedPage
+		}
+	}
+
+	page = csrfHeaderRegex.ReplaceAllString(page, "${1}"+redacted+"'")
+	for _, secret := range secrets {
+		if len(secret) > 0 {
+			page = strings.Replace(page, secret, redacted, -1)
+		}
+	}
+	return page
+}
+
+// reportParseFailure logs fields of page which couldn't be parsed and
+// captures page if diagnostics are enabled
+func (s *SamadClient) reportParseFailure(page string, parseErrors ...error) {
+	for _, err := range parseErrors {
+		logrus.WithError(err).WithField("provider", s.provider.Name).Warnln("Samad page is parsed partially")
+	}
+	if s.config.Diagnostics == nil {
+		return
+	}
+
+	err := s.config.Diagnostics.CaptureParseFailure(s.provider.Name, page, parseErrors,
+		s.sessionData.username, s.sessionData.password, s.sessionData.csrf)
+	if err != nil {
+		logrus.WithError(err).Errorln("can't capture Samad page")
+	}
+}
@@ -0,0 +1,73 @@
+package selfservice
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactPage(t *testing.T) {
+	availableReserve, err := ioutil.ReadFile("../test/samad/reserve_available.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+
+	page := redactPage(string(availableReserve), []string{"9513035", "secret-password"})
+	for _, secret := range []string{"d6a2102a-afcd-42dd-af7a-8e623973ae32", "9513035", "هادی ابکنار"} {
+		if strings.Contains(page, secret) {
+			t.Errorf("redacted page contains %q", secret)
+		}
+	}
+	if !strings.Contains(page, "userWeekReserves[0].programId") {
+		t.Error("redacted page has lost reservation form")
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sarioself-diagnostics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alerts := make(chan *ParseFailure, 10)
+	diagnostics := NewDiagnostics(dir, time.Hour, func(failure *ParseFailure) {
+		alerts <- failure
+	})
+	parseErrors := []error{ParseError{Field: "credit", Selector: creditSelector}}
+	for i := 0; i < 3; i++ {
+		if err := diagnostics.CaptureParseFailure("aut", "<html></html>", parseErrors); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = diagnostics.CaptureParseFailure("aut", "<html></html>",
+		[]error{ParseError{Field: "date", Selector: mealDateSelector}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A page is saved for each distinct failure
+	pages, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+	reports, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(pages) != 2 || len(reports) != 2 {
+		t.Errorf("saved %v pages and %v reports, want 2 and 2", len(pages), len(reports))
+	}
+
+	// Only the first failure is alerted in interval
+	select {
+	case failure := <-alerts:
+		if failure.Provider != "aut" || len(failure.Errors) != 1 || len(failure.Path) == 0 {
+			t.Errorf("alerted failure is %+v", failure)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failure isn't alerted")
+	}
+	select {
+	case failure := <-alerts:
+		t.Errorf("failure %+v is alerted in interval", failure)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
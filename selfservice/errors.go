@@ -1,8 +1,47 @@
 package selfservice
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidCredentials is returned when Samad rejects username or password
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrWrongCaptcha is returned when Samad rejects captcha of login form
+	ErrWrongCaptcha = errors.New("wrong captcha")
+	// ErrAccountLocked is returned when user's account is locked by Samad
+	ErrAccountLocked = errors.New("account is locked")
+	// ErrSessionExpired is returned when Samad session isn't valid anymore
+	ErrSessionExpired = errors.New("Samad session is expired")
+	// ErrFoodNotFound is returned when food isn't in menu of this week or
+	// the next one
+	ErrFoodNotFound = errors.New("food isn't found in menu")
+	// ErrFoodUnavailable is returned when reservation of food can't be
+	// changed anymore
+	ErrFoodUnavailable = errors.New("food isn't available for reservation")
+	// ErrWeekOutOfRange is returned when requested week is too far from the
+	// current one
+	ErrWeekOutOfRange = errors.New("week is too far from the current week")
+	// ErrSelfNotFound is returned when user can't reserve food from the
+	// requested self
+	ErrSelfNotFound = errors.New("self isn't found")
+	// ErrFoodNotReserved is returned when reservation of a food which isn't
+	// reserved is changed
+	ErrFoodNotReserved = errors.New("food isn't reserved")
+	// ErrSelfChangeFailed is returned when Samad accepts changing self of a
+	// reservation but reservation isn't moved to the new self
+	ErrSelfChangeFailed = errors.New("self of reservation isn't changed")
+	// ErrChangeSelfUnsupported is returned when self of a reservation is
+	// changed on a reservation service which can't do it yet
+	ErrChangeSelfUnsupported = errors.New("changing self of reservation isn't supported")
+	// ErrCountNotAllowed is returned when Samad doesn't allow reserving the
+	// requested number of portions of a food
+	ErrCountNotAllowed = errors.New("number of portions isn't allowed")
 )
 
 type SamadError struct {
@@ -13,3 +52,66 @@ type SamadError struct {
 func (e SamadError) Error() string {
 	return fmt.Sprintf("%v: %v", e.When, e.What)
 }
+
+// CaptchaRequiredError is returned when captcha of login can't be solved
+// automatically, login continues after user solves Challenge
+type CaptchaRequiredError struct {
+	Challenge *LoginChallenge
+}
+
+func (e *CaptchaRequiredError) Error() string {
+	return "captcha should be solved by user"
+}
+
+// ParseError is returned when a field of Samad page can't be found or
+// parsed, which usually means Samad's layout has changed
+type ParseError struct {
+	// Field is name of the field which is failed
+	Field string
+	// Selector is the CSS selector or pattern which is used to find field
+	Selector string
+	// Value is the text which couldn't be parsed, it's empty if field isn't
+	// found at all
+	Value string
+	Err   error
+}
+
+func (e ParseError) Error() string {
+	if len(e.Value) == 0 {
+		return fmt.Sprintf("can't find %v in Samad page using %q", e.Field, e.Selector)
+	}
+	if e.Err == nil {
+		return fmt.Sprintf("can't parse %v from %q of %q", e.Field, e.Value, e.Selector)
+	}
+	return fmt.Sprintf("can't parse %v from %q of %q: %v", e.Field, e.Value, e.Selector, e.Err)
+}
+
+// NetworkError is returned when Samad can't be reached or doesn't respond in
+// time
+type NetworkError struct {
+	URL string
+	Err error
+}
+
+func (e NetworkError) Error() string {
+	return fmt.Sprintf("can't reach %v: %v", e.URL, e.Err)
+}
+
+// Timeout checks whether request is failed because of a deadline
+func (e NetworkError) Timeout() bool {
+	if e.Err == context.DeadlineExceeded {
+		return true
+	}
+	netError, ok := e.Err.(net.Error)
+	return ok && netError.Timeout()
+}
+
+// StatusError is returned when Samad responds with an unsuccessful HTTP status
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return fmt.Sprintf("Samad returned %v status code for %v", e.StatusCode, e.URL)
+}
@@ -0,0 +1,90 @@
+package selfservice
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// get sends GET request to Samad and retries it with exponential backoff
+// when Samad can't be reached or fails with a server error. Body of returned
+// response should be closed by caller.
+func (s *SamadClient) get(ctx context.Context, address string) (*http.Response, error) {
+	backoff := s.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		response, err := s.do(ctx, "GET", address, nil)
+		if err == nil || attempt >= s.config.Retries || ctx.Err() != nil || !isRetriable(err) {
+			return response, err
+		}
+
+		logrus.WithError(err).WithFields(logrus.Fields{
+			"url":     address,
+			"attempt": attempt + 1,
+		}).Warnln("Samad request failed, retrying")
+		select {
+		case <-ctx.Done():
+			return nil, NetworkError{URL: address, Err: ctx.Err()}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post sends form to Samad, it isn't retried since it may change reservations.
+// Body of returned response should be closed by caller.
+func (s *SamadClient) post(ctx context.Context, address string, form *url.Values) (*http.Response, error) {
+	return s.do(ctx, "POST", address, form)
+}
+
+func (s *SamadClient) do(ctx context.Context, method, address string, form *url.Values) (*http.Response, error) {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	request, err := http.NewRequest(method, address, body)
+	if err != nil {
+		return nil, err
+	}
+	if form != nil {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("X-Csrf-Token", s.sessionData.csrf)
+	}
+
+	response, err := s.httpClient.Do(request.WithContext(ctx))
+	if err != nil {
+		if urlError, ok := err.(*url.Error); ok {
+			err = urlError.Err
+		}
+		return nil, NetworkError{URL: address, Err: err}
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		return nil, StatusError{URL: address, StatusCode: response.StatusCode}
+	}
+	return response, nil
+}
+
+// closeResponse drains and closes body of response, so its connection can
+// be reused
+func closeResponse(response *http.Response) {
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+}
+
+// isRetriable checks whether a failed request may succeed if it's sent again
+func isRetriable(err error) bool {
+	switch err := err.(type) {
+	case NetworkError:
+		return true
+	case StatusError:
+		return err.StatusCode >= 500
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package selfservice
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func newTestSamadClient(t *testing.T, config SamadConfig) *SamadClient {
+	config.ManualCaptcha = true
+	client, err := newSamadClient(AUTSamadProvider, "9531000", "secret", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestSamadClientRetries(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestSamadClient(t, SamadConfig{Retries: 2, RetryBackoff: time.Millisecond})
+	response, err := client.get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatalf("get() failed after retries, %v", err)
+	}
+	closeResponse(response)
+	if requests != 3 {
+		t.Errorf("get() sent %v requests, want 3", requests)
+	}
+
+	requests = 0
+	_, err = client.post(context.Background(), server.URL, &url.Values{})
+	if statusError, ok := errors.Cause(err).(StatusError); !ok || statusError.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("post() returned %v, want StatusError", err)
+	}
+	if requests != 1 {
+		t.Errorf("post() sent %v requests, want 1", requests)
+	}
+}
+
+func TestSamadClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	client := newTestSamadClient(t, SamadConfig{RequestTimeout: 10 * time.Millisecond})
+	_, err := client.get(context.Background(), server.URL)
+	if networkError, ok := errors.Cause(err).(NetworkError); !ok || !networkError.Timeout() {
+		t.Errorf("get() returned %v, want timeout NetworkError", err)
+	}
+
+	client = newTestSamadClient(t, SamadConfig{Retries: 5, RetryBackoff: time.Second})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = client.get(ctx, server.URL)
+	if networkError, ok := errors.Cause(err).(NetworkError); !ok || !networkError.Timeout() {
+		t.Errorf("get() returned %v, want timeout NetworkError", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("get() retried after deadline of context")
+	}
+}
@@ -0,0 +1,92 @@
+package selfservice
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ClientPool keeps logged-in Samad clients of users, so commands of a user
+// can reuse the same session instead of logging in every time
+type ClientPool struct {
+	lock        sync.Mutex
+	clients     map[string]*pooledClient
+	idleTimeout time.Duration
+	config      SamadConfig
+}
+
+type pooledClient struct {
+	lock     sync.Mutex
+	client   *SamadClient
+	password string
+
+	// lastUsed is guarded by lock of ClientPool
+	lastUsed time.Time
+}
+
+// NewClientPool creates new instance of ClientPool. Clients which haven't been
+// used for idleTimeout are dropped and will be logged in again on next use.
+func NewClientPool(idleTimeout time.Duration, config SamadConfig) *ClientPool {
+	return &ClientPool{
+		clients:     make(map[string]*pooledClient),
+		idleTimeout: idleTimeout,
+		config:      config,
+	}
+}
+
+// Get returns a logged-in client of the user on Samad of provider, it logs
+// in only if there isn't any alive client for the user. When user should
+// solve the captcha, a CaptchaRequiredError is returned and the next Get after
+// solving it returns the logged-in client.
+func (p *ClientPool) Get(ctx context.Context, provider SamadProvider, username, password string) (*SamadClient, error) {
+	entry := p.getEntry(poolKey(provider.Name, username))
+
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	if entry.client == nil || entry.password != password {
+		client, err := newSamadClient(provider, username, password, p.config)
+		if err != nil {
+			return nil, err
+		}
+		entry.client = client
+		entry.password = password
+	}
+	if err := entry.client.ensureLoggedIn(ctx); err != nil {
+		return nil, err
+	}
+	return entry.client, nil
+}
+
+// Remove drops client of the user on Samad of provider from pool
+func (p *ClientPool) Remove(provider SamadProvider, username string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.clients, poolKey(provider.Name, username))
+}
+
+func poolKey(providerName, username string) string {
+	return providerName + "#" + username
+}
+
+// getEntry returns pool entry of key and drops idle entries
+func (p *ClientPool) getEntry(key string) *pooledClient {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	for key, entry := range p.clients {
+		if now.Sub(entry.lastUsed) > p.idleTimeout {
+			delete(p.clients, key)
+		}
+	}
+
+	entry, ok := p.clients[key]
+	if !ok {
+		entry = &pooledClient{}
+		p.clients[key] = entry
+	}
+	entry.lastUsed = now
+	return entry
+}
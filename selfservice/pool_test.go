@@ -0,0 +1,67 @@
+package selfservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aryahadii/sarioself/test/fakesamad"
+)
+
+func TestClientPool(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	ctx := context.Background()
+	if err := provider.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	idleTimeout := 200 * time.Millisecond
+	pool := NewClientPool(idleTimeout, SamadConfig{CaptchaSolver: fixedCaptchaSolver(fakesamad.CaptchaAnswer)})
+	logins := func() int {
+		return server.Requests("/j_security_check")
+	}
+
+	client, err := pool.Get(ctx, provider, fakeSamadUsername, fakeSamadPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		reused, err := pool.Get(ctx, provider, fakeSamadUsername, fakeSamadPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reused != client {
+			t.Error("Get() didn't reuse client of user")
+		}
+	}
+	if logins() != 1 {
+		t.Fatalf("pool logged in %v times, want 1", logins())
+	}
+
+	// Changed password
+	server.Password = "changed"
+	if _, err := pool.Get(ctx, provider, fakeSamadUsername, "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if logins() != 2 {
+		t.Errorf("pool logged in %v times after change of password, want 2", logins())
+	}
+
+	// Idle clients are dropped
+	time.Sleep(2 * idleTimeout)
+	if _, err := pool.Get(ctx, provider, fakeSamadUsername, "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if logins() != 3 {
+		t.Errorf("pool logged in %v times after client became idle, want 3", logins())
+	}
+
+	pool.Remove(provider, fakeSamadUsername)
+	if _, err := pool.Get(ctx, provider, fakeSamadUsername, "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if logins() != 4 {
+		t.Errorf("pool logged in %v times after client was removed, want 4", logins())
+	}
+}
@@ -0,0 +1,266 @@
+package selfservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aryahadii/sarioself/model"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/yaa110/go-persian-calendar/ptime"
+)
+
+// SamadPaths contains paths of Samad pages which are used by SamadClient
+type SamadPaths struct {
+	LoginPage    string `mapstructure:"login-page"`
+	LoginBackend string `mapstructure:"login-backend"`
+	Captcha      string `mapstructure:"captcha"`
+	Reservation  string `mapstructure:"reservation"`
+}
+
+// SamadProvider is a Samad instance which is run by a university
+type SamadProvider struct {
+	Name    string `mapstructure:"name"`
+	Scheme  string `mapstructure:"scheme"`
+	BaseURL string `mapstructure:"base-url"`
+	// Paths overrides default paths of Samad pages
+	Paths SamadPaths `mapstructure:"paths"`
+	// ReserveDeadline is hours before start of a day which reservations of
+	// the day can be changed, Samad pages don't show it
+	ReserveDeadline int `mapstructure:"reserve-deadline"`
+	// ServingWindows are when meals are served, defaults are used for the
+	// missing ones
+	ServingWindows ServingWindows `mapstructure:"serving-windows"`
+	// SelfServingWindows overrides ServingWindows of selves by their IDs
+	SelfServingWindows map[string]ServingWindows `mapstructure:"self-serving-windows"`
+}
+
+// ServingWindow is when a meal is served, Start and End are like 12:30 in
+// Iran's time zone
+type ServingWindow struct {
+	Start string `mapstructure:"start"`
+	End   string `mapstructure:"end"`
+}
+
+// ServingWindows are serving windows of meals of a self
+type ServingWindows struct {
+	Breakfast ServingWindow `mapstructure:"breakfast"`
+	Lunch     ServingWindow `mapstructure:"lunch"`
+	Dinner    ServingWindow `mapstructure:"dinner"`
+}
+
+var (
+	defaultSamadPaths = SamadPaths{
+		LoginPage:    "/loginpage.rose",
+		LoginBackend: "/j_security_check",
+		Captcha:      "/captcha.jpg",
+		Reservation:  "/nurture/user/multi/reserve/reserve.rose",
+	}
+
+	defaultServingWindows = ServingWindows{
+		Breakfast: ServingWindow{Start: "07:00", End: "09:00"},
+		Lunch:     ServingWindow{Start: "11:30", End: "14:00"},
+		Dinner:    ServingWindow{Start: "19:00", End: "21:00"},
+	}
+
+	// AUTSamadProvider is Samad of Amirkabir University of Technology
+	AUTSamadProvider = SamadProvider{
+		Name:            "aut",
+		Scheme:          "http",
+		BaseURL:         "samad.aut.ac.ir",
+		ReserveDeadline: 24,
+	}
+)
+
+// LoadSamadProviders reads samad.providers of config, AUTSamadProvider is
+// used if no provider is defined
+func LoadSamadProviders(config *viper.Viper) (map[string]SamadProvider, error) {
+	var providers []SamadProvider
+	if err := config.UnmarshalKey("samad.providers", &providers); err != nil {
+		return nil, err
+	}
+	if len(providers) == 0 {
+		providers = append(providers, AUTSamadProvider)
+	}
+	return NewSamadProviders(providers)
+}
+
+// NewSamadProviders validates providers and maps them by their names
+func NewSamadProviders(providers []SamadProvider) (map[string]SamadProvider, error) {
+	providersMap := make(map[string]SamadProvider)
+	for _, provider := range providers {
+		if err := provider.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := providersMap[provider.Name]; ok {
+			return nil, fmt.Errorf("Samad provider %v is defined more than once", provider.Name)
+		}
+		providersMap[provider.Name] = provider
+	}
+	return providersMap, nil
+}
+
+// validate checks provider and fills its missing fields with defaults, it
+// doesn't change anything which is shared with other copies of provider
+func (p *SamadProvider) validate() error {
+	if len(p.Name) == 0 {
+		return fmt.Errorf("Samad provider has no name")
+	}
+	if len(p.BaseURL) == 0 {
+		return fmt.Errorf("Samad provider %v has no base URL", p.Name)
+	}
+	if len(p.Scheme) == 0 {
+		p.Scheme = "http"
+	}
+	p.BaseURL = strings.TrimSuffix(strings.TrimPrefix(p.BaseURL, p.Scheme+"://"), "/")
+
+	if p.ReserveDeadline < 0 {
+		return fmt.Errorf("reserve deadline of Samad provider %v is negative", p.Name)
+	}
+
+	if len(p.Paths.LoginPage) == 0 {
+		p.Paths.LoginPage = defaultSamadPaths.LoginPage
+	}
+	if len(p.Paths.LoginBackend) == 0 {
+		p.Paths.LoginBackend = defaultSamadPaths.LoginBackend
+	}
+	if len(p.Paths.Captcha) == 0 {
+		p.Paths.Captcha = defaultSamadPaths.Captcha
+	}
+	if len(p.Paths.Reservation) == 0 {
+		p.Paths.Reservation = defaultSamadPaths.Reservation
+	}
+
+	p.ServingWindows = p.ServingWindows.withDefaults(defaultServingWindows)
+	if err := p.ServingWindows.validate(); err != nil {
+		return errors.Wrapf(err, "serving windows of Samad provider %v are invalid", p.Name)
+	}
+	// Copies of provider share the map, so windows with defaults are put in
+	// a new one
+	selfServingWindows := make(map[string]ServingWindows, len(p.SelfServingWindows))
+	for selfID, windows := range p.SelfServingWindows {
+		windows = windows.withDefaults(p.ServingWindows)
+		if err := windows.validate(); err != nil {
+			return errors.Wrapf(err, "serving windows of self %v of Samad provider %v are invalid", selfID, p.Name)
+		}
+		selfServingWindows[selfID] = windows
+	}
+	p.SelfServingWindows = selfServingWindows
+	return nil
+}
+
+// servingWindows returns serving windows of meals of self
+func (p *SamadProvider) servingWindows(selfID string) ServingWindows {
+	if windows, ok := p.SelfServingWindows[selfID]; ok {
+		return windows
+	}
+	return p.ServingWindows
+}
+
+func (p *SamadProvider) reserveDeadline() time.Duration {
+	return time.Duration(p.ReserveDeadline) * time.Hour
+}
+
+// window returns serving window of mealTime
+func (w ServingWindows) window(mealTime model.MealTime) ServingWindow {
+	switch mealTime {
+	case model.MealTimeBreakfast:
+		return w.Breakfast
+	case model.MealTimeDinner:
+		return w.Dinner
+	default:
+		return w.Lunch
+	}
+}
+
+// withDefaults fills missing windows from defaults
+func (w ServingWindows) withDefaults(defaults ServingWindows) ServingWindows {
+	if len(w.Breakfast.Start) == 0 && len(w.Breakfast.End) == 0 {
+		w.Breakfast = defaults.Breakfast
+	}
+	if len(w.Lunch.Start) == 0 && len(w.Lunch.End) == 0 {
+		w.Lunch = defaults.Lunch
+	}
+	if len(w.Dinner.Start) == 0 && len(w.Dinner.End) == 0 {
+		w.Dinner = defaults.Dinner
+	}
+	return w
+}
+
+func (w ServingWindows) validate() error {
+	if err := w.Breakfast.validate(); err != nil {
+		return errors.Wrap(err, "breakfast")
+	}
+	if err := w.Lunch.validate(); err != nil {
+		return errors.Wrap(err, "lunch")
+	}
+	if err := w.Dinner.validate(); err != nil {
+		return errors.Wrap(err, "dinner")
+	}
+	return nil
+}
+
+func (w ServingWindow) validate() error {
+	startHour, startMinute, err := parseClock(w.Start)
+	if err != nil {
+		return err
+	}
+	endHour, endMinute, err := parseClock(w.End)
+	if err != nil {
+		return err
+	}
+	if startHour*60+startMinute >= endHour*60+endMinute {
+		return fmt.Errorf("window %v-%v ends before it starts", w.Start, w.End)
+	}
+	return nil
+}
+
+// on returns start and end of window on a Jalali date, window should be
+// validated before
+func (w ServingWindow) on(year, month, day int) (start, end time.Time) {
+	startHour, startMinute, _ := parseClock(w.Start)
+	endHour, endMinute, _ := parseClock(w.End)
+	start = ptime.Date(year, ptime.Month(month), day, startHour, startMinute, 0, 0, ptime.Iran()).Time()
+	end = ptime.Date(year, ptime.Month(month), day, endHour, endMinute, 0, 0, ptime.Iran()).Time()
+	return start, end
+}
+
+// parseClock parses times of day like 12:30
+func parseClock(clock string) (hour, minute int, err error) {
+	parts := strings.Split(clock, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("time %q isn't in hour:minute format", clock)
+	}
+	if hour, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, err
+	}
+	if minute, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, err
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, 0, fmt.Errorf("time %q is out of range", clock)
+	}
+	return hour, minute, nil
+}
+
+func (p *SamadProvider) url(path string) string {
+	return fmt.Sprintf("%s://%s/%s", p.Scheme, p.BaseURL, strings.TrimPrefix(path, "/"))
+}
+
+func (p *SamadProvider) loginPageURL() string {
+	return p.url(p.Paths.LoginPage)
+}
+
+func (p *SamadProvider) loginBackendURL() string {
+	return p.url(p.Paths.LoginBackend)
+}
+
+func (p *SamadProvider) captchaURL() string {
+	return p.url(p.Paths.Captcha)
+}
+
+func (p *SamadProvider) reservationURL() string {
+	return p.url(p.Paths.Reservation)
+}
@@ -0,0 +1,98 @@
+package selfservice
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestNewSamadProviders(t *testing.T) {
+	providers, err := NewSamadProviders([]SamadProvider{
+		AUTSamadProvider,
+		{
+			Name:    "example",
+			Scheme:  "https",
+			BaseURL: "https://samad.example.ac.ir/",
+			Paths:   SamadPaths{Reservation: "/reserve.rose"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aut := providers["aut"]
+	if aut.reservationURL() != "http://samad.aut.ac.ir/nurture/user/multi/reserve/reserve.rose" {
+		t.Errorf("reservationURL() of AUT is %v", aut.reservationURL())
+	}
+	example := providers["example"]
+	if example.reservationURL() != "https://samad.example.ac.ir/reserve.rose" {
+		t.Errorf("reservationURL() of example is %v", example.reservationURL())
+	}
+	if example.captchaURL() != "https://samad.example.ac.ir/captcha.jpg" {
+		t.Errorf("captchaURL() of example is %v", example.captchaURL())
+	}
+
+	if _, err := NewSamadProviders([]SamadProvider{AUTSamadProvider, AUTSamadProvider}); err == nil {
+		t.Error("NewSamadProviders() accepted duplicate providers")
+	}
+	if _, err := NewSamadProviders([]SamadProvider{{Name: "empty"}}); err == nil {
+		t.Error("NewSamadProviders() accepted provider without base URL")
+	}
+	if _, err := NewSamadProviders([]SamadProvider{{Name: "negative", BaseURL: "samad.example.ac.ir", ReserveDeadline: -1}}); err == nil {
+		t.Error("NewSamadProviders() accepted provider with negative reserve deadline")
+	}
+}
+
+func TestSamadProviderServingWindows(t *testing.T) {
+	selfServingWindows := map[string]ServingWindows{
+		"8": {Lunch: ServingWindow{Start: "12:00", End: "13:30"}},
+	}
+	providers, err := NewSamadProviders([]SamadProvider{
+		{
+			Name:               "example",
+			BaseURL:            "samad.example.ac.ir",
+			ServingWindows:     ServingWindows{Dinner: ServingWindow{Start: "18:30", End: "20:00"}},
+			SelfServingWindows: selfServingWindows,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	example := providers["example"]
+	if windows := example.servingWindows("1"); windows.Breakfast != defaultServingWindows.Breakfast ||
+		windows.Lunch != defaultServingWindows.Lunch || windows.Dinner.Start != "18:30" {
+		t.Errorf("servingWindows(1) = %+v", windows)
+	}
+	if windows := example.servingWindows("8"); windows.Lunch.Start != "12:00" || windows.Dinner.Start != "18:30" {
+		t.Errorf("servingWindows(8) = %+v", windows)
+	}
+	if len(selfServingWindows["8"].Dinner.Start) > 0 {
+		t.Error("NewSamadProviders() changed serving windows of config")
+	}
+
+	// Clients of users validate the same provider concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newSamadClient(example, "9531000", "secret", SamadConfig{ManualCaptcha: true})
+		}()
+	}
+	wg.Wait()
+
+	invalidWindows := []ServingWindow{
+		{Start: "12:00"},
+		{Start: "14:00", End: "12:00"},
+		{Start: "25:00", End: "26:00"},
+		{Start: "noon", End: "13:00"},
+	}
+	for _, window := range invalidWindows {
+		_, err := NewSamadProviders([]SamadProvider{
+			{Name: "example", BaseURL: "samad.example.ac.ir", ServingWindows: ServingWindows{Lunch: window}},
+		})
+		if err == nil {
+			t.Errorf("NewSamadProviders() accepted serving window %+v", window)
+		}
+	}
+}
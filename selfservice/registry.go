@@ -0,0 +1,83 @@
+package selfservice
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ClientFactory returns a logged-in client of a user on a reservation service
+type ClientFactory func(ctx context.Context, username, password string) (Client, error)
+
+var (
+	clientFactoriesLock sync.RWMutex
+	clientFactories     = map[string]ClientFactory{}
+)
+
+// RegisterProvider makes reservation service available under name, which is
+// the value that is kept in ReservationService of users
+func RegisterProvider(name string, factory ClientFactory) error {
+	return registerProvider(name, factory, false)
+}
+
+// registerProvider registers factory under name, it replaces the factory which
+// is already registered under name only if replace is set
+func registerProvider(name string, factory ClientFactory, replace bool) error {
+	clientFactoriesLock.Lock()
+	defer clientFactoriesLock.Unlock()
+
+	if len(name) == 0 {
+		return fmt.Errorf("reservation service has no name")
+	}
+	if _, ok := clientFactories[name]; ok && !replace {
+		return fmt.Errorf("reservation service %v is registered more than once", name)
+	}
+	clientFactories[name] = factory
+	return nil
+}
+
+// NewClient returns a logged-in client of the user on reservationService
+func NewClient(ctx context.Context, reservationService, username, password string) (Client, error) {
+	clientFactoriesLock.RLock()
+	factory, ok := clientFactories[reservationService]
+	clientFactoriesLock.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("reservation service %v isn't registered", reservationService)
+	}
+	return factory(ctx, username, password)
+}
+
+// ProviderNames returns names of all registered reservation services
+func ProviderNames() []string {
+	clientFactoriesLock.RLock()
+	defer clientFactoriesLock.RUnlock()
+
+	var names []string
+	for name := range clientFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterSamadProviders registers each of Samad providers as a reservation
+// service whose clients are kept in pool. Providers which are registered
+// before are replaced, so config can be loaded again with a new pool.
+func RegisterSamadProviders(providers map[string]SamadProvider, pool *ClientPool) error {
+	for name, provider := range providers {
+		provider := provider
+		err := registerProvider(name, func(ctx context.Context, username, password string) (Client, error) {
+			client, err := pool.Get(ctx, provider, username, password)
+			if err != nil {
+				return nil, err
+			}
+			return client, nil
+		}, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
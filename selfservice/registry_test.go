@@ -0,0 +1,82 @@
+package selfservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aryahadii/sarioself/test/fakesamad"
+)
+
+// fakeClient is a Client of registry tests, they don't call its methods
+type fakeClient struct {
+	Client
+	username string
+}
+
+func TestRegisterProvider(t *testing.T) {
+	err := RegisterProvider("fake-registry", func(ctx context.Context, username, password string) (Client, error) {
+		if password != "secret" {
+			return nil, ErrInvalidCredentials
+		}
+		return &fakeClient{username: username}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterProvider("fake-registry", nil); err == nil {
+		t.Error("RegisterProvider() accepted duplicate name")
+	}
+
+	client, err := NewClient(context.Background(), "fake-registry", "9531000", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake, ok := client.(*fakeClient); !ok || fake.username != "9531000" {
+		t.Errorf("NewClient() returned %v", client)
+	}
+	if _, err := NewClient(context.Background(), "fake-registry", "9531000", "wrong"); err != ErrInvalidCredentials {
+		t.Errorf("NewClient() with wrong password returned %v", err)
+	}
+	if _, err := NewClient(context.Background(), "not-registered", "9531000", "secret"); err == nil {
+		t.Error("NewClient() accepted unknown reservation service")
+	}
+
+	found := false
+	for _, name := range ProviderNames() {
+		if name == "fake-registry" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("ProviderNames() = %v doesn't contain fake-registry", ProviderNames())
+	}
+}
+
+func TestRegisterSamadProviders(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	provider.Name = "fake-samad-registry"
+	providers, err := NewSamadProviders([]SamadProvider{provider})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Providers are registered again when bot is set up again
+	config := SamadConfig{CaptchaSolver: fixedCaptchaSolver(fakesamad.CaptchaAnswer)}
+	oldPool := NewClientPool(time.Minute, config)
+	if err := RegisterSamadProviders(providers, oldPool); err != nil {
+		t.Fatal(err)
+	}
+	pool := NewClientPool(time.Minute, config)
+	if err := RegisterSamadProviders(providers, pool); err != nil {
+		t.Fatalf("RegisterSamadProviders() can't register providers again, %v", err)
+	}
+
+	if _, err := NewClient(context.Background(), provider.Name, fakeSamadUsername, fakeSamadPassword); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.clients) != 1 || len(oldPool.clients) != 0 {
+		t.Errorf("NewClient() didn't use pool of the last registration")
+	}
+}
@@ -0,0 +1,640 @@
+package selfservice
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aryahadii/sarioself/model"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/yaa110/go-persian-calendar/ptime"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	loginErrorSelector = "#errorMessages, .errorMessage, .alert-danger, .error"
+
+	// maxWeekDistance is the farthest week from the current one which can be
+	// loaded, each week away costs a request to Samad
+	maxWeekDistance = 12
+)
+
+var (
+	csrfRegex = regexp.MustCompile(`'X-CSRF-TOKEN' : '(.*)'`)
+
+	// loginErrorPhrases maps messages of Samad's login page to login errors,
+	// order matters since captcha messages may mention user too
+	loginErrorPhrases = []struct {
+		err     error
+		phrases []string
+	}{
+		{ErrWrongCaptcha, []string{"کد امنیتی", "captcha"}},
+		{ErrAccountLocked, []string{"قفل", "مسدود", "locked"}},
+		{ErrInvalidCredentials, []string{"نام کاربری", "رمز عبور", "کلمه عبور", "Bad credentials"}},
+	}
+)
+
+// SamadClient is client of universities' restaurants which use Samad
+type SamadClient struct {
+	lock        sync.Mutex
+	provider    SamadProvider
+	config      SamadConfig
+	sessionData *userSessionData
+	httpClient  *http.Client
+	loggedIn    bool
+	// selves are selves of the last page which is loaded
+	selves []Self
+}
+
+// SamadConfig contains settings of Samad clients
+type SamadConfig struct {
+	// CaptchaAttempts is number of logins with a fresh captcha before
+	// giving up on a wrong captcha
+	CaptchaAttempts int
+	// CaptchaSolver is used to read captchas of login form, it can be nil
+	// if ManualCaptcha is set
+	CaptchaSolver CaptchaSolver
+	// ManualCaptcha makes login return a CaptchaRequiredError instead of
+	// ErrWrongCaptcha when CaptchaSolver fails, so user can solve the captcha
+	ManualCaptcha bool
+	// RequestTimeout limits each request to Samad, zero means no limit
+	RequestTimeout time.Duration
+	// Retries is number of times a failed GET request is sent again
+	Retries int
+	// RetryBackoff is the delay before first retry, it's doubled for each
+	// of next retries
+	RetryBackoff time.Duration
+	// Diagnostics captures pages which can't be parsed, it can be nil
+	Diagnostics *Diagnostics
+}
+
+// LoginChallenge is a login which is waiting for its captcha to be solved
+type LoginChallenge struct {
+	// CaptchaImage is the JPEG image of captcha
+	CaptchaImage []byte
+	client       *SamadClient
+}
+
+// NewSamadClient creates new instance of SamadClient for Samad of provider
+// and logs it in
+func NewSamadClient(ctx context.Context, provider SamadProvider, username, password string,
+	config SamadConfig) (*SamadClient, error) {
+	samad, err := newSamadClient(provider, username, password, config)
+	if err != nil {
+		return nil, err
+	}
+	if err := samad.logIn(ctx); err != nil {
+		return nil, err
+	}
+	return samad, nil
+}
+
+// newSamadClient creates new instance of SamadClient without logging in
+func newSamadClient(provider SamadProvider, username, password string, config SamadConfig) (*SamadClient, error) {
+	if err := provider.validate(); err != nil {
+		return nil, err
+	}
+	if config.CaptchaAttempts < 1 {
+		config.CaptchaAttempts = 1
+	}
+	if config.CaptchaSolver == nil && !config.ManualCaptcha {
+		return nil, fmt.Errorf("no captcha solver is set")
+	}
+	samad := &SamadClient{
+		provider: provider,
+		config:   config,
+	}
+
+	// Cookie Jar
+	jarOption := &cookiejar.Options{
+		PublicSuffixList: publicsuffix.List,
+	}
+	cookieJar, _ := cookiejar.New(jarOption)
+	samad.httpClient = &http.Client{
+		Jar:     cookieJar,
+		Timeout: config.RequestTimeout,
+	}
+
+	samad.sessionData = &userSessionData{
+		username: username,
+		password: password,
+		jar:      cookieJar,
+	}
+	return samad, nil
+}
+
+// ensureLoggedIn logs client in if it doesn't have a valid session
+func (s *SamadClient) ensureLoggedIn(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.loggedIn {
+		return nil
+	}
+	return s.logIn(ctx)
+}
+
+// logIn creates a new Samad session using user's credentials, it retries
+// with a new captcha as long as Samad rejects the captcha
+func (s *SamadClient) logIn(ctx context.Context) error {
+	s.loggedIn = false
+
+	var err error
+	if s.config.CaptchaSolver != nil {
+		for attempt := 1; attempt <= s.config.CaptchaAttempts; attempt++ {
+			logger := logrus.WithFields(logrus.Fields{
+				"username": s.sessionData.username,
+				"attempt":  attempt,
+			})
+
+			err = s.tryLogIn(ctx)
+			if err == nil {
+				logger.Infoln("logged in to Samad")
+				return nil
+			}
+			if errors.Cause(err) != ErrWrongCaptcha {
+				logger.WithError(err).Warnln("can't login to Samad")
+				return err
+			}
+			logger.Infoln("Samad rejected captcha")
+		}
+	}
+
+	if s.config.ManualCaptcha {
+		return s.newCaptchaRequiredError(ctx)
+	}
+	return err
+}
+
+// tryLogIn makes one login attempt with a fresh CSRF token and captcha
+func (s *SamadClient) tryLogIn(ctx context.Context) error {
+	challenge, err := s.newLoginChallenge(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Captcha
+	image, err := jpeg.Decode(bytes.NewReader(challenge.CaptchaImage))
+	if err != nil {
+		return errors.Wrap(err, "can't decode captcha")
+	}
+	captcha, err := s.config.CaptchaSolver.Solve(image)
+	if err != nil {
+		return errors.Wrap(ErrWrongCaptcha, err.Error())
+	}
+
+	// Login
+	err = s.login(ctx, captcha)
+	if err != nil {
+		return errors.Wrap(err, "can't login to Samad")
+	}
+
+	return nil
+}
+
+// newLoginChallenge starts a new login, it gets a fresh CSRF token and captcha
+func (s *SamadClient) newLoginChallenge(ctx context.Context) (*LoginChallenge, error) {
+	// Session data
+	err := s.createConnection(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't create connection")
+	}
+	if len(s.sessionData.csrf) == 0 {
+		return nil, fmt.Errorf("CSRF isn't valid")
+	}
+
+	// Captcha
+	captchaImage, err := s.getCaptcha(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get captcha")
+	}
+
+	return &LoginChallenge{
+		CaptchaImage: captchaImage,
+		client:       s,
+	}, nil
+}
+
+// newCaptchaRequiredError starts a new login whose captcha should be solved
+// by user
+func (s *SamadClient) newCaptchaRequiredError(ctx context.Context) error {
+	challenge, err := s.newLoginChallenge(ctx)
+	if err != nil {
+		return err
+	}
+	logrus.WithField("username", s.sessionData.username).
+		Infoln("captcha should be solved by user")
+	return &CaptchaRequiredError{Challenge: challenge}
+}
+
+// Solve completes login using captcha which is solved by user. It returns a
+// CaptchaRequiredError with a new challenge if Samad rejects the captcha.
+func (c *LoginChallenge) Solve(ctx context.Context, captcha string) error {
+	s := c.client
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.login(ctx, strings.TrimSpace(captcha))
+	if errors.Cause(err) == ErrWrongCaptcha {
+		return s.newCaptchaRequiredError(ctx)
+	}
+	return err
+}
+
+// createConnection creates new connection to Samad and returns
+// CSRF token of session
+func (s *SamadClient) createConnection(ctx context.Context) error {
+	response, err := s.get(ctx, s.provider.loginPageURL())
+	if err != nil {
+		return errors.Wrap(err, "can't connect to Samad")
+	}
+	defer closeResponse(response)
+
+	body, _ := ioutil.ReadAll(response.Body)
+	csrf, err := findCSRF(string(body))
+	if err != nil {
+		s.reportParseFailure(string(body), err)
+		return errors.Wrap(err, "can't find CSRF token in login page")
+	}
+	s.sessionData.csrf = csrf
+	return nil
+}
+
+// getCaptcha downloads captcha image of current session from Samad
+func (s *SamadClient) getCaptcha(ctx context.Context) ([]byte, error) {
+	response, err := s.get(ctx, s.provider.captchaURL())
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get captcha from Samad")
+	}
+	defer closeResponse(response)
+
+	return ioutil.ReadAll(response.Body)
+}
+
+// login tries to log into Samad website
+func (s *SamadClient) login(ctx context.Context, captcha string) error {
+	form := url.Values{}
+	form.Set("_csrf", s.sessionData.csrf)
+	form.Set("username", s.sessionData.username)
+	form.Set("password", s.sessionData.password)
+	form.Set("captcha_input", captcha)
+
+	response, err := s.post(ctx, s.provider.loginBackendURL(), &form)
+	if err != nil {
+		return errors.Wrap(err, "can't login to Samad")
+	}
+	defer closeResponse(response)
+
+	body, _ := ioutil.ReadAll(response.Body)
+	bodyString := string(body)
+	if isLoginPage(response, bodyString) {
+		return getLoginError(bodyString)
+	}
+
+	csrf, err := findCSRF(bodyString)
+	if err != nil {
+		s.reportParseFailure(bodyString, err)
+		return errors.Wrap(err, "can't find CSRF token after login")
+	}
+	s.sessionData.csrf = csrf
+	s.loggedIn = true
+	return nil
+}
+
+// GetSelves returns selves which user can reserve food from, they're loaded
+// only if no page of Samad is loaded yet
+func (s *SamadClient) GetSelves(ctx context.Context) ([]Self, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.selves) == 0 {
+		if _, err := s.getSamadReservePage(ctx); err != nil {
+			return nil, errors.Wrap(err, "can't get first page of Samad")
+		}
+	}
+	return append([]Self(nil), s.selves...), nil
+}
+
+// GetAvailableFoods returns a list of all foods of self that can be reserved
+// It checks this week and the next one
+func (s *SamadClient) GetAvailableFoods(ctx context.Context, selfID string) (map[time.Time][]*model.Food, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	pages, err := s.getReservePages(ctx, selfID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each date has its own location, keys are moved to one location since
+	// times of different locations are different keys
+	location := ptime.Iran()
+	availableFoods := make(map[time.Time][]*model.Food)
+	for _, page := range pages {
+		for _, food := range page.Foods() {
+			if food.Status != model.FoodStatusUnavailable {
+				date := food.Date.In(location)
+				availableFoods[date] = append(availableFoods[date], food)
+			}
+		}
+	}
+	return availableFoods, nil
+}
+
+// GetReservations returns foods of this week and the next one which are
+// reserved by user in any of selves
+func (s *SamadClient) GetReservations(ctx context.Context) ([]*model.Food, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	page, err := s.getSamadReservePage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get first page of Samad")
+	}
+	selfIDs := []string{page.SelfID}
+	for _, self := range page.Selves {
+		if self.ID != page.SelfID {
+			selfIDs = append(selfIDs, self.ID)
+		}
+	}
+
+	var reservations []*model.Food
+	for _, selfID := range selfIDs {
+		pages, err := s.getReservePages(ctx, selfID)
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			for _, food := range page.Foods() {
+				if food.Status == model.FoodStatusReserved {
+					reservations = append(reservations, food)
+				}
+			}
+		}
+	}
+	return reservations, nil
+}
+
+// getReservePages returns reservation pages of self in this week and the
+// next one
+func (s *SamadClient) getReservePages(ctx context.Context, selfID string) ([]*ReservePage, error) {
+	page, err := s.getWeekReservePage(ctx, selfID, 0)
+	if err != nil {
+		return nil, err
+	}
+	nextPage, err := s.getNextSamadReservePage(ctx, page)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get second page of Samad")
+	}
+	return []*ReservePage{page, nextPage}, nil
+}
+
+// GetWeekFoods returns foods of self in week, which is relative to the
+// current week
+func (s *SamadClient) GetWeekFoods(ctx context.Context, selfID string, week int) ([]*model.Food, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	page, err := s.getWeekReservePage(ctx, selfID, week)
+	if err != nil {
+		return nil, err
+	}
+	return page.Foods(), nil
+}
+
+// getWeekReservePage returns reservation page of self in week, which is
+// relative to the current week. Samad only shows the current week, so client
+// walks to week a week at a time.
+func (s *SamadClient) getWeekReservePage(ctx context.Context, selfID string, week int) (*ReservePage, error) {
+	if week > maxWeekDistance || week < -maxWeekDistance {
+		return nil, ErrWeekOutOfRange
+	}
+	page, err := s.getSamadReservePage(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "can't get first page of Samad")
+	}
+	if page, err = s.showSelf(ctx, page, selfID); err != nil {
+		return nil, err
+	}
+
+	steps := week
+	if !page.WeekStart.IsZero() {
+		steps = week - WeekOf(page.WeekStart)
+	}
+	if steps > 2*maxWeekDistance || steps < -2*maxWeekDistance {
+		return nil, ErrWeekOutOfRange
+	}
+	for ; steps > 0; steps-- {
+		if page, err = s.submitReservePage(ctx, page, "method:showNextWeek"); err != nil {
+			return nil, errors.Wrapf(err, "can't get week %d of Samad", week)
+		}
+	}
+	for ; steps < 0; steps++ {
+		if page, err = s.submitReservePage(ctx, page, "method:showPreviousWeek"); err != nil {
+			return nil, errors.Wrapf(err, "can't get week %d of Samad", week)
+		}
+	}
+	return page, nil
+}
+
+// showSelf returns page of self in the week of page, empty selfID is the self
+// which Samad shows by default
+func (s *SamadClient) showSelf(ctx context.Context, page *ReservePage, selfID string) (*ReservePage, error) {
+	if len(selfID) == 0 || page.SelfID == selfID {
+		return page, nil
+	}
+	if !page.hasSelf(selfID) {
+		return nil, ErrSelfNotFound
+	}
+	page.form.Set("selectedSelfDefId", selfID)
+	selfPage, err := s.submitReservePage(ctx, page, "method:showPanel")
+	if err != nil {
+		return nil, errors.Wrapf(err, "can't get page of self %v", selfID)
+	}
+	return selfPage, nil
+}
+
+// Reserve reserves count portions of food in self, it does nothing if count
+// portions of food are already reserved
+func (s *SamadClient) Reserve(ctx context.Context, selfID string, date *time.Time, foodID string, count int) (*ReservationResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if count < 1 {
+		return nil, ErrCountNotAllowed
+	}
+	return s.applyReservations(ctx, selfID, []ReservationChange{{Date: *date, FoodID: foodID, Count: count}})
+}
+
+// Cancel cancels reservation of food in self, it does nothing if food isn't
+// reserved
+func (s *SamadClient) Cancel(ctx context.Context, selfID string, date *time.Time, foodID string) (*ReservationResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.applyReservations(ctx, selfID, []ReservationChange{{Date: *date, FoodID: foodID}})
+}
+
+// ApplyReservations reserves and cancels foods of self, Samad's form of each
+// week is submitted once with all changes of that week
+func (s *SamadClient) ApplyReservations(ctx context.Context, selfID string, changes []ReservationChange) (*ReservationResult, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.applyReservations(ctx, selfID, changes)
+}
+
+// applyReservations applies changes week by week. If a change fails, changes
+// of its week and the weeks after it aren't submitted, but changes of the
+// weeks before it are and they're returned in result along with the error.
+func (s *SamadClient) applyReservations(ctx context.Context, selfID string, changes []ReservationChange) (*ReservationResult, error) {
+	weekChanges := make(map[int][]ReservationChange)
+	var weeks []int
+	for _, change := range changes {
+		if change.Count < 0 {
+			return nil, ErrCountNotAllowed
+		}
+		week := WeekOf(change.Date)
+		if _, ok := weekChanges[week]; !ok {
+			weeks = append(weeks, week)
+		}
+		weekChanges[week] = append(weekChanges[week], change)
+	}
+	sort.Ints(weeks)
+
+	result := &ReservationResult{}
+	for _, week := range weeks {
+		err := s.applyWeekReservations(ctx, selfID, week, weekChanges[week], result)
+		result.updateStatus()
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// applyWeekReservations applies changes to form of week and submits it if
+// any food isn't already in its requested state. Since Samad may ignore
+// changes without any message, week is loaded again to check them.
+func (s *SamadClient) applyWeekReservations(ctx context.Context, selfID string, week int, changes []ReservationChange, result *ReservationResult) error {
+	page, err := s.getWeekReservePage(ctx, selfID, week)
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for _, change := range changes {
+		date := change.Date
+		foodChanged, err := page.setReservation(&date, change.FoodID, change.Count)
+		if err != nil {
+			return err
+		}
+		changed = changed || foodChanged
+	}
+	if !changed {
+		result.Applied = append(result.Applied, changes...)
+		result.Credit = page.Credit
+		return nil
+	}
+
+	reason, err := s.submitReservation(ctx, page)
+	if err != nil {
+		return errors.Wrap(err, "can't submit food reservation")
+	}
+	checkedPage, err := s.getWeekReservePage(ctx, selfID, week)
+	if err != nil {
+		return errors.Wrap(err, "can't check food reservation")
+	}
+
+	for _, change := range changes {
+		if checkedPage.isApplied(change) {
+			result.Applied = append(result.Applied, change)
+		} else {
+			result.Failed = append(result.Failed, change)
+		}
+	}
+	if len(result.Reason) == 0 {
+		result.Reason = reason
+	}
+	result.Credit = checkedPage.Credit
+	if checkedPage.parseError("credit") != nil {
+		// Credit of the submitted form, which is calculated the way
+		// Samad's form does it
+		result.Credit = page.remainCredit()
+	}
+	return nil
+}
+
+// ChangeSelf returns ErrChangeSelfUnsupported, form of change self dialog
+// isn't verified against a real Samad (see changeSelfMethod) and guesses of it
+// shouldn't be sent to Samad of users
+func (s *SamadClient) ChangeSelf(ctx context.Context, selfID string, date *time.Time, foodID, newSelfID string) error {
+	return ErrChangeSelfUnsupported
+}
+
+// changeSelf moves reservation of food from self to newSelfID, reservation is
+// kept in self if Samad doesn't move it. ErrSelfChangeFailed is returned if
+// reservation isn't found on page of the new self afterwards.
+func (s *SamadClient) changeSelf(ctx context.Context, selfID string, date *time.Time, foodID, newSelfID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if selfID == newSelfID {
+		return nil
+	}
+	week := WeekOf(*date)
+	page, err := s.getWeekReservePage(ctx, selfID, week)
+	if err != nil {
+		return err
+	}
+	values, err := page.changeSelfValues(date, foodID, newSelfID)
+	if err != nil {
+		return err
+	}
+	weekReserve := page.findReserve(date, foodID)
+
+	resultPage, err := s.postReservePage(ctx, values)
+	if err != nil {
+		return errors.Wrap(err, "can't send change of self to Samad")
+	}
+	if len(resultPage.Error) > 0 {
+		return SamadError{
+			What: resultPage.Error,
+			When: time.Now(),
+		}
+	}
+
+	// Check reservation on page of the new self
+	newSelfPage, err := s.getWeekReservePage(ctx, newSelfID, week)
+	if err != nil {
+		return errors.Wrap(err, "can't check change of self")
+	}
+	if movedReserve := newSelfPage.findSameReserve(weekReserve); movedReserve == nil || !movedReserve.Selected {
+		return ErrSelfChangeFailed
+	}
+	return nil
+}
+
+func (s *SamadClient) GetCredit(ctx context.Context) (model.Money, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	page, err := s.getSamadReservePage(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "can't get first page of Samad")
+	}
+	if err := page.parseError("credit"); err != nil {
+		return 0, err
+	}
+	return page.Credit, nil
+}
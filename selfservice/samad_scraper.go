@@ -1,59 +1,450 @@
 package selfservice
 
 import (
+	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/aryahadii/sarioself/model"
 	"github.com/pkg/errors"
+	"github.com/yaa110/go-persian-calendar/ptime"
 )
 
-// findSamadFoods creates a list of all foods in Samad's HTML file
-func findSamadFoods(samadPage string) ([]*model.Food, error) {
-	var foods []*model.Food
-	document, err := goquery.NewDocumentFromReader(strings.NewReader(samadPage))
-	if err != nil {
-		return foods, errors.Wrap(err, "can't init goquery on document")
-	}
+const (
+	creditSelector        = "#creditId"
+	errorMessagesSelector = "#errorMessages"
+	mealTableSelector     = `table[align="center"]`
+	mealDateSelector      = `td[valign="middle"]`
+	// mealHeaderSelector finds headers of meal table, mtid of each one is
+	// mealTypeId of foods of the meal
+	mealHeaderSelector = `div[id^="ftActionContainerId_"][mtid]`
 
-	document.Find(":input[type=checkbox]").Each(func(i int, s *goquery.Selection) {
-		foods = append(foods, makeFoodObject(s))
-	})
+	// changeSelfMethod submits selfChangeReserveId and weekStartDateTimeAjx,
+	// which change self dialog of Samad fills, along with the new self in
+	// newSelfField. Only the first two are hidden inputs of reservation page
+	// (see test/samad), the dialog itself is loaded by Ajax and hasn't been
+	// captured, so changeSelfMethod and newSelfField aren't verified against
+	// a real Samad and SamadClient.ChangeSelf doesn't submit them until the
+	// request of the dialog is captured. Result of submitting them should be
+	// checked on the page of the new self.
+	changeSelfMethod = "method:changeSelf"
+	newSelfField     = "selfChangeSelfId"
+)
+
+// ReservePage is the reservation page of Samad which shows foods of a week
+type ReservePage struct {
+	// WeekStart is the time which Samad uses to identify week of page
+	WeekStart time.Time
+	// Credit is credit of user
+	Credit model.Money
+	// Selves are restaurants which user can reserve food from
+	Selves []Self
+	// SelfID is ID of the self whose foods are shown
+	SelfID string
+	// Reserves are foods of the week
+	Reserves []*WeekReserve
+	// Error is the error message which Samad shows on page
+	Error string
+	// ParseErrors are fields of page which couldn't be parsed, page
+	// contains everything else
+	ParseErrors []error
 
-	return foods, nil
+	csrf string
+	// form contains hidden inputs of reservation form
+	form url.Values
+	// mealTimes maps mealTypeId of foods to meal times
+	mealTimes map[string]model.MealTime
 }
 
-// extractFormInputValues returns form values which are needed to get next page
-// in Samad reservation page
-func extractFormInputValues(samadPage string) (*url.Values, error) {
-	values := &url.Values{}
+// Self is a restaurant of university
+type Self struct {
+	ID   string
+	Name string
+}
+
+// WeekReserve is a food of reservation form, it's named after
+// userWeekReserves fields of Samad's form
+type WeekReserve struct {
+	Index           int
+	Food            *model.Food
+	ID              string
+	ProgramID       string
+	MealTypeID      string
+	FoodTypeID      string
+	SelfID          string
+	ProgramDateTime time.Time
+	Selected        bool
+	SelectedCount   int
+	// MaxCount is the largest selectedCount which Samad offers for food
+	MaxCount int
+	Disabled bool
+}
 
+// parseReservePage parses reservation page of Samad. Fields which can't be
+// parsed are skipped and the first ParseError is returned along with the
+// partially parsed page.
+func parseReservePage(samadPage string) (*ReservePage, error) {
 	document, err := goquery.NewDocumentFromReader(strings.NewReader(samadPage))
 	if err != nil {
 		return nil, errors.Wrap(err, "can't init goquery on document")
 	}
+
+	page := &ReservePage{form: url.Values{}}
+	page.Error = strings.TrimSpace(document.Find(errorMessagesSelector).Text())
+	creditText := strings.TrimSpace(document.Find(creditSelector).Text())
+	if credit, err := strconv.Atoi(creditText); err != nil {
+		page.ParseErrors = append(page.ParseErrors,
+			ParseError{Field: "credit", Selector: creditSelector, Value: creditText, Err: err})
+	} else {
+		page.Credit = model.Rials(credit)
+	}
+
 	document.Find(":input[type=hidden]").Each(func(i int, s *goquery.Selection) {
 		if name, got := s.Attr("name"); got {
 			if val, got := s.Attr("value"); got {
-				values.Set(name, val)
+				page.form.Set(name, val)
 			}
 		}
 	})
+	// Client keeps its previous token if page has none
+	page.csrf, _ = findCSRF(samadPage)
+	if len(page.csrf) == 0 {
+		page.csrf = page.form.Get("_csrf")
+	}
+	if weekStart, err := parseMillis(page.form.Get("weekStartDateTime")); err != nil {
+		page.ParseErrors = append(page.ParseErrors, ParseError{Field: "week start",
+			Selector: "input[name=weekStartDateTime]", Value: page.form.Get("weekStartDateTime"), Err: err})
+	} else {
+		page.WeekStart = weekStart
+	}
+	page.SelfID = page.form.Get("selectedSelfDefId")
+	document.Find("select[name=selectedSelfDefIdCombo] option").Each(func(i int, s *goquery.Selection) {
+		id, _ := s.Attr("value")
+		page.Selves = append(page.Selves, Self{
+			ID:   id,
+			Name: strings.TrimSpace(s.Text()),
+		})
+	})
+
+	page.mealTimes = parseMealTimes(document)
 	document.Find(":input[type=checkbox]").Each(func(i int, s *goquery.Selection) {
-		if name, got := s.Attr("name"); got {
-			if _, got := s.Attr("checked"); got {
-				values.Set(name, "true")
-			}
+		reserve, err := page.parseWeekReserve(document, s)
+		if err != nil {
+			page.ParseErrors = append(page.ParseErrors, err)
 		}
+		page.Reserves = append(page.Reserves, reserve)
 	})
-	document.Find("select").Each(func(i int, s *goquery.Selection) {
-		if name, got := s.Attr("name"); got {
-			if _, got := s.Parent().Siblings().Children().First().Attr("checked"); got {
-				values.Set(name, "1")
-			}
+
+	if len(page.ParseErrors) > 0 {
+		return page, page.ParseErrors[0]
+	}
+	return page, nil
+}
+
+// parseError returns ParseError of field if it couldn't be parsed
+func (p *ReservePage) parseError(field string) error {
+	for _, err := range p.ParseErrors {
+		if parseErr, ok := err.(ParseError); ok && parseErr.Field == field {
+			return parseErr
+		}
+	}
+	return nil
+}
+
+// parseMealTimes maps mealTypeId of foods to meal times by names of meals in
+// headers of meal table
+func parseMealTimes(document *goquery.Document) map[string]model.MealTime {
+	mealTimes := make(map[string]model.MealTime)
+	for mealTypeID, mealTime := range defaultMealTimes {
+		mealTimes[mealTypeID] = mealTime
+	}
+	document.Find(mealHeaderSelector).Each(func(i int, s *goquery.Selection) {
+		name := strings.Fields(s.Closest("td").Text())
+		if len(name) == 0 {
+			return
+		}
+		if mealTime, ok := mealTimeNames[name[0]]; ok {
+			mealTimes[s.AttrOr("mtid", "")] = mealTime
+		}
+	})
+	return mealTimes
+}
+
+// findCSRF finds CSRF token which Samad puts in scripts of its pages
+func findCSRF(samadPage string) (string, error) {
+	csrf := csrfRegex.FindStringSubmatch(samadPage)
+	if len(csrf) < 2 || len(csrf[1]) == 0 {
+		return "", ParseError{Field: "CSRF token", Selector: csrfRegex.String()}
+	}
+	return csrf[1], nil
+}
+
+// parseWeekReserve parses a food of reservation form from its checkbox. State
+// of checkbox is always parsed, so form can be submitted without changing
+// foods which aren't parsed completely.
+func (p *ReservePage) parseWeekReserve(document *goquery.Document, checkbox *goquery.Selection) (*WeekReserve, error) {
+	food, parseErr := makeFoodObject(checkbox, p.mealTimes)
+	reserve := &WeekReserve{Food: food}
+
+	name, _ := checkbox.Attr("name")
+	prefix := strings.TrimSuffix(name, ".selected")
+	index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(prefix, "userWeekReserves["), "]"))
+	if err != nil {
+		// Without index, state of food can't be submitted
+		reserve.Disabled = true
+		return reserve, ParseError{Field: "reserve index", Selector: "input[type=checkbox][name]", Value: name, Err: err}
+	}
+	reserve.Index = index
+	reserve.ID = p.form.Get(prefix + ".id")
+	reserve.ProgramID = p.form.Get(prefix + ".programId")
+	reserve.MealTypeID = p.form.Get(prefix + ".mealTypeId")
+	reserve.FoodTypeID = p.form.Get(prefix + ".foodTypeId")
+	reserve.SelfID = p.form.Get(prefix + ".selfId")
+	reserve.Food.SelfID = reserve.SelfID
+	reserve.Food.ReserveID = reserve.ID
+	reserve.Food.ProgramID = reserve.ProgramID
+	reserve.Food.MealTypeID = reserve.MealTypeID
+	reserve.Food.FoodTypeID = reserve.FoodTypeID
+	if programDateTime, err := parseMillis(p.form.Get(prefix + ".programDateTime")); err == nil {
+		reserve.ProgramDateTime = programDateTime
+	}
+
+	_, reserve.Selected = checkbox.Attr("checked")
+	_, reserve.Disabled = checkbox.Attr("disabled")
+	countOptions := document.Find(fmt.Sprintf("select[name='%s.selectedCount'] option", prefix))
+	if count := p.form.Get(prefix + ".selectedCount"); len(count) > 0 {
+		reserve.SelectedCount, _ = strconv.Atoi(count)
+	} else if reserve.Selected {
+		reserve.SelectedCount = 1
+		if count, err := strconv.Atoi(countOptions.Filter("[selected]").AttrOr("value", "")); err == nil {
+			reserve.SelectedCount = count
+		}
+	}
+	reserve.MaxCount = 1
+	countOptions.Each(func(i int, s *goquery.Selection) {
+		if count, err := strconv.Atoi(s.AttrOr("value", "")); err == nil && count > reserve.MaxCount {
+			reserve.MaxCount = count
 		}
 	})
+	reserve.Food.Count = reserve.SelectedCount
+	reserve.Food.MaxCount = reserve.MaxCount
+	return reserve, parseErr
+}
+
+// Foods returns foods of the week, foods whose date couldn't be parsed are
+// skipped
+func (p *ReservePage) Foods() []*model.Food {
+	foods := make([]*model.Food, 0, len(p.Reserves))
+	for _, reserve := range p.Reserves {
+		if reserve.Food.Date != nil {
+			foods = append(foods, reserve.Food)
+		}
+	}
+	return foods
+}
+
+// setServingWindows sets date of foods of page to serving window of their
+// meal in their self, windows returns serving windows of a self
+func (p *ReservePage) setServingWindows(windows func(selfID string) ServingWindows) {
+	for _, reserve := range p.Reserves {
+		if reserve.Food.Date == nil {
+			continue
+		}
+		date := ptime.New(reserve.Food.Date.In(ptime.Iran()))
+		start, end := windows(reserve.SelfID).window(reserve.Food.MealTime).on(date.Year(), int(date.Month()), date.Day())
+		reserve.Food.Date, reserve.Food.ServedUntil = &start, &end
+	}
+}
+
+// setDeadlines sets time after which foods of page can't be changed, which
+// is deadline before start of their day. Samad disables checkbox of days which
+// are already locked, so they're locked at now whatever their deadline is.
+func (p *ReservePage) setDeadlines(deadline time.Duration, now time.Time) {
+	for _, reserve := range p.Reserves {
+		if reserve.ProgramDateTime.IsZero() {
+			continue
+		}
+		changeableUntil := reserve.ProgramDateTime.Add(-deadline)
+		if reserve.Disabled && changeableUntil.After(now) {
+			changeableUntil = now
+		}
+		reserve.Food.ChangeableUntil = &changeableUntil
+	}
+}
+
+// hasSelf checks whether user can reserve food from self
+func (p *ReservePage) hasSelf(selfID string) bool {
+	for _, self := range p.Selves {
+		if self.ID == selfID {
+			return true
+		}
+	}
+	return false
+}
+
+// findReserve returns food of date whose ID is foodID
+func (p *ReservePage) findReserve(date *time.Time, foodID string) *WeekReserve {
+	for _, reserve := range p.Reserves {
+		if reserve.Food.Date != nil && reserve.Food.Date.Equal(*date) && reserve.Food.ID == foodID {
+			return reserve
+		}
+	}
+	return nil
+}
+
+// isApplied checks whether change is in effect on page
+func (p *ReservePage) isApplied(change ReservationChange) bool {
+	weekReserve := p.findReserve(&change.Date, change.FoodID)
+	if weekReserve == nil {
+		return false
+	}
+	if change.Count == 0 {
+		return !weekReserve.Selected
+	}
+	return weekReserve.Selected && weekReserve.SelectedCount == change.Count
+}
+
+// findSameReserve returns food of page which is the same as reserve, reserve
+// can be from page of another self
+func (p *ReservePage) findSameReserve(reserve *WeekReserve) *WeekReserve {
+	for _, sameReserve := range p.Reserves {
+		if sameReserve.ProgramDateTime.Equal(reserve.ProgramDateTime) &&
+			sameReserve.MealTypeID == reserve.MealTypeID && sameReserve.FoodTypeID == reserve.FoodTypeID {
+			return sameReserve
+		}
+	}
+	return nil
+}
+
+// changeSelfValues returns values of reservation form which move reservation
+// of food of date to newSelfID
+func (p *ReservePage) changeSelfValues(date *time.Time, foodID, newSelfID string) (*url.Values, error) {
+	weekReserve := p.findReserve(date, foodID)
+	if weekReserve == nil {
+		return nil, ErrFoodNotFound
+	}
+	if !weekReserve.Selected || len(weekReserve.ID) == 0 {
+		return nil, ErrFoodNotReserved
+	}
+	if weekReserve.Disabled {
+		return nil, ErrFoodUnavailable
+	}
+	if !p.hasSelf(newSelfID) {
+		return nil, ErrSelfNotFound
+	}
 
+	values := p.formValues(changeSelfMethod)
+	values.Set("selfChangeReserveId", weekReserve.ID)
+	values.Set("weekStartDateTimeAjx", p.form.Get("weekStartDateTime"))
+	values.Set(newSelfField, newSelfID)
 	return values, nil
 }
+
+// setReservation reserves count portions of food in form of page, zero count
+// cancels reservation of food. It returns false if food is already in the
+// requested state.
+func (p *ReservePage) setReservation(date *time.Time, foodID string, count int) (bool, error) {
+	weekReserve := p.findReserve(date, foodID)
+	if weekReserve == nil {
+		return false, ErrFoodNotFound
+	}
+	reserve := count > 0
+	if weekReserve.Selected == reserve && (!reserve || weekReserve.SelectedCount == count) {
+		return false, nil
+	}
+	if weekReserve.Disabled {
+		return false, ErrFoodUnavailable
+	}
+	if count < 0 || count > weekReserve.MaxCount {
+		return false, ErrCountNotAllowed
+	}
+	// Foods without index would be dropped from submitted form
+	if err := p.parseError("reserve index"); err != nil {
+		return false, err
+	}
+
+	creditChange := p.setCount(weekReserve, count)
+	if reserve {
+		// Foods of a meal are alternatives, Samad unchecks the others when
+		// one of them is checked and gives back their credit
+		for _, sibling := range p.Reserves {
+			if sibling != weekReserve && sibling.Selected && !sibling.Disabled &&
+				sibling.MealTypeID == weekReserve.MealTypeID &&
+				sibling.ProgramDateTime.Equal(weekReserve.ProgramDateTime) {
+				creditChange += p.setCount(sibling, 0)
+			}
+		}
+	}
+
+	remainCredit := p.remainCredit() + creditChange
+	p.form.Set("remainCredit", strconv.Itoa(remainCredit.InRials()))
+	return true, nil
+}
+
+// setCount sets count of reserved portions of food in form, it returns the
+// credit which is given back by the change
+func (p *ReservePage) setCount(weekReserve *WeekReserve, count int) model.Money {
+	previousCount := 0
+	if weekReserve.Selected {
+		previousCount = weekReserve.SelectedCount
+	}
+	weekReserve.SelectedCount = count
+	weekReserve.Selected = count > 0
+	weekReserve.Food.Count = count
+	weekReserve.Food.Status = model.FoodStatusReservable
+	if weekReserve.Selected {
+		weekReserve.Food.Status = model.FoodStatusReserved
+	}
+	return weekReserve.Food.Price.Times(previousCount - count)
+}
+
+// remainCredit returns credit which Samad's form calculates after changes
+// of reservations, it's zero if form has no credit
+func (p *ReservePage) remainCredit() model.Money {
+	remainCredit, _ := strconv.Atoi(p.form.Get("remainCredit"))
+	return model.Rials(remainCredit)
+}
+
+// formValues returns values of reservation form which submit it using method,
+// method is one of submit buttons of form like method:showNextWeek
+func (p *ReservePage) formValues(method string) *url.Values {
+	values := &url.Values{}
+	for name, value := range p.form {
+		(*values)[name] = append([]string(nil), value...)
+	}
+	for _, reserve := range p.Reserves {
+		// State of disabled foods is kept in hidden inputs
+		if reserve.Disabled {
+			continue
+		}
+		prefix := fmt.Sprintf("userWeekReserves[%d]", reserve.Index)
+		if reserve.Selected {
+			values.Set(prefix+".selected", "true")
+			values.Set(prefix+".selectedCount", strconv.Itoa(reserve.SelectedCount))
+		} else {
+			values.Del(prefix + ".selected")
+			values.Del(prefix + ".selectedCount")
+		}
+	}
+	// Combo of selves isn't a hidden input, browsers send it along
+	// with selectedSelfDefId
+	if selfID := p.form.Get("selectedSelfDefId"); len(selfID) > 0 {
+		values.Set("selectedSelfDefIdCombo", selfID)
+	}
+	if len(method) > 0 {
+		values.Set(method, "Submit")
+	}
+	return values
+}
+
+// parseMillis parses milliseconds since epoch which Samad uses for times
+func parseMillis(value string) (time.Time, error) {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond)), nil
+}
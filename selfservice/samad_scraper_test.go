@@ -2,7 +2,12 @@ package selfservice
 
 import (
 	"io/ioutil"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/aryahadii/sarioself/model"
 )
 
 func TestFindSamadFoods(t *testing.T) {
@@ -11,16 +16,16 @@ func TestFindSamadFoods(t *testing.T) {
 		t.Fatalf("can't open test html, %v", err)
 	}
 
-	foods, err := findSamadFoods(string(fileBytes))
+	page, err := parseReservePage(string(fileBytes))
 	if err != nil {
-		t.Fatalf("can't find Samad foods, %v", err)
+		t.Fatalf("can't parse Samad page, %v", err)
 	}
-	if len(foods) == 0 {
+	if len(page.Foods()) == 0 {
 		t.Error("Foods list is empty!")
 	}
 }
 
-func TestExtractFormInputValues(t *testing.T) {
+func TestReservePageFormValues(t *testing.T) {
 	notavailableReserve, err := ioutil.ReadFile("../test/samad/reserve_notavailable.html")
 	if err != nil {
 		t.Fatalf("can't open test html, %v", err)
@@ -33,6 +38,7 @@ func TestExtractFormInputValues(t *testing.T) {
 		"selfChangeReserveId":                  "",
 		"weekStartDateTimeAjx":                 "1508614772520",
 		"selectedSelfDefId":                    "1",
+		"selectedSelfDefIdCombo":               "1",
 		"userWeekReserves[0].selected":         "true",
 		"userWeekReserves[0].selectedCount":    "1",
 		"userWeekReserves[0].id":               "8903111",
@@ -126,18 +132,408 @@ func TestExtractFormInputValues(t *testing.T) {
 		"_csrf": "d6a2102a-afcd-42dd-af7a-8e623973ae32",
 	}
 
-	values, err := extractFormInputValues(string(notavailableReserve))
+	page, err := parseReservePage(string(notavailableReserve))
 	if err != nil {
 		t.Fatal(err)
 	}
+	values := page.formValues("")
 	for key, val := range expectedValues {
 		if values.Get(key) != val {
-			t.Errorf("extractFormInputValues() made a mistake. (%v, %v) instead of (%v, %v)",
+			t.Errorf("formValues() made a mistake. (%v, %v) instead of (%v, %v)",
 				key, values.Get(key), key, val)
 		}
 	}
 	if len(map[string][]string(*values)) != len(expectedValues) {
-		t.Errorf("extractFormInputValues(): len aren't the same: %v, %v", len(expectedValues),
+		t.Errorf("formValues(): len aren't the same: %v, %v", len(expectedValues),
 			len(map[string][]string(*values)))
 	}
 }
+
+func TestGetLoginError(t *testing.T) {
+	testCases := []struct {
+		page     string
+		expected error
+	}{
+		{`<div id="errorMessages">کد امنیتی وارد شده صحیح نیست</div>`, ErrWrongCaptcha},
+		{`<div class="alert-danger">نام کاربری یا رمز عبور اشتباه است</div>`, ErrInvalidCredentials},
+		{`<div id="errorMessages">حساب کاربری شما قفل شده است</div>`, ErrAccountLocked},
+	}
+
+	for _, testCase := range testCases {
+		if err := getLoginError(testCase.page); err != testCase.expected {
+			t.Errorf("getLoginError() returned %v instead of %v", err, testCase.expected)
+		}
+	}
+
+	err := getLoginError(`<div id="errorMessages">خطای ناشناخته</div>`)
+	if samadError, ok := err.(SamadError); !ok || samadError.What != "خطای ناشناخته" {
+		t.Errorf("getLoginError() returned %v instead of SamadError", err)
+	}
+	if err := getLoginError(`<html></html>`); err == nil {
+		t.Error("getLoginError() returned nil for page without message")
+	}
+}
+
+func TestParseReservePage(t *testing.T) {
+	notavailableReserve, err := ioutil.ReadFile("../test/samad/reserve_notavailable.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+
+	page, err := parseReservePage(string(notavailableReserve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Credit != -24549 {
+		t.Errorf("Credit = %v, want -24549", page.Credit)
+	}
+	if page.WeekStart.Unix() != 1508614772 {
+		t.Errorf("WeekStart = %v, want 1508614772", page.WeekStart.Unix())
+	}
+	if page.SelfID != "1" || len(page.Selves) != 2 || page.Selves[1].ID != "8" {
+		t.Errorf("SelfID = %v, Selves = %v", page.SelfID, page.Selves)
+	}
+	if !page.hasSelf("8") || page.hasSelf("2") {
+		t.Errorf("hasSelf() doesn't match Selves %v", page.Selves)
+	}
+	if len(page.Reserves) != 10 {
+		t.Fatalf("found %v reserves, want 10", len(page.Reserves))
+	}
+
+	reserve := page.Reserves[3]
+	if reserve.Index != 3 || reserve.ID != "8903112" || reserve.ProgramID != "986253" ||
+		reserve.MealTypeID != "2" || reserve.FoodTypeID != "153" || reserve.SelfID != "1" ||
+		reserve.Food.SelfID != "1" {
+		t.Errorf("reserve 3 isn't parsed correctly, %+v", reserve)
+	}
+	if !reserve.Selected || reserve.SelectedCount != 1 || !reserve.Disabled {
+		t.Errorf("state of reserve 3 isn't parsed correctly, %+v", reserve)
+	}
+	if reserve.ProgramDateTime.Unix() != 1508617800 {
+		t.Errorf("ProgramDateTime = %v, want 1508617800", reserve.ProgramDateTime.Unix())
+	}
+	if reserve.Food.MealTime != model.MealTimeLunch {
+		t.Errorf("MealTime = %v, want MealTimeLunch", reserve.Food.MealTime)
+	}
+	if page.mealTimes["1"] != model.MealTimeBreakfast || page.mealTimes["2"] != model.MealTimeLunch ||
+		page.mealTimes["3"] != model.MealTimeDinner {
+		t.Errorf("meal times of headers are %v", page.mealTimes)
+	}
+	// Lunch of 1396/08/01 is served from 11:30 to 14:00
+	if reserve.Food.Date.Unix() != 1508659200 || reserve.Food.ServedUntil.Unix() != 1508668200 {
+		t.Errorf("serving window is %v-%v, want 1508659200-1508668200",
+			reserve.Food.Date.Unix(), reserve.Food.ServedUntil.Unix())
+	}
+
+	page.setServingWindows(func(selfID string) ServingWindows {
+		windows := defaultServingWindows
+		windows.Lunch = ServingWindow{Start: "12:00", End: "13:00"}
+		return windows
+	})
+	if reserve.Food.Date.Unix() != 1508661000 || reserve.Food.ServedUntil.Unix() != 1508664600 {
+		t.Errorf("serving window of self is %v-%v, want 1508661000-1508664600",
+			reserve.Food.Date.Unix(), reserve.Food.ServedUntil.Unix())
+	}
+}
+
+// Captured pages only have foods of lunch, so foods of other meals are made by
+// changing meal type of the first food
+func TestParseReservePageMealTimes(t *testing.T) {
+	notavailableReserve, err := ioutil.ReadFile("../test/samad/reserve_notavailable.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+	page, err := parseReservePage(string(notavailableReserve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lunch := page.Reserves[0].Food
+
+	for _, test := range []struct {
+		mealTypeID string
+		// header is mtid of a header which is changed to mealTypeID
+		header   string
+		mealTime model.MealTime
+		// serving window relative to start of lunch
+		start, end time.Duration
+	}{
+		{"1", "", model.MealTimeBreakfast, -270 * time.Minute, -150 * time.Minute},
+		{"3", "", model.MealTimeDinner, 450 * time.Minute, 570 * time.Minute},
+		{"4", "3", model.MealTimeDinner, 450 * time.Minute, 570 * time.Minute},
+	} {
+		html := strings.Replace(string(notavailableReserve), `mealtypeid="2"`, `mealtypeid="`+test.mealTypeID+`"`, 1)
+		if len(test.header) > 0 {
+			html = strings.Replace(html, `mtid="`+test.header+`"`, `mtid="`+test.mealTypeID+`"`, 1)
+		}
+		page, err := parseReservePage(html)
+		if err != nil {
+			t.Errorf("meal type %v: %v", test.mealTypeID, err)
+			continue
+		}
+		food := page.Reserves[0].Food
+		if food.MealTime != test.mealTime {
+			t.Errorf("meal type %v: MealTime = %v, want %v", test.mealTypeID, food.MealTime, test.mealTime)
+		}
+		if !food.Date.Equal(lunch.Date.Add(test.start)) || !food.ServedUntil.Equal(lunch.Date.Add(test.end)) {
+			t.Errorf("meal type %v: serving window is %v-%v", test.mealTypeID, food.Date, food.ServedUntil)
+		}
+	}
+
+	html := strings.Replace(string(notavailableReserve), `mealtypeid="2"`, `mealtypeid="5"`, 1)
+	if page, _ := parseReservePage(html); page.parseError("meal type") == nil {
+		t.Error("meal type without header is parsed")
+	}
+}
+
+func TestReservePageSetReservation(t *testing.T) {
+	availableReserve, err := ioutil.ReadFile("../test/samad/reserve_available.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+	notavailableReserve, err := ioutil.ReadFile("../test/samad/reserve_notavailable.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+	availableDate := time.Unix(1509177600, 0)
+	notavailableDate := time.Unix(1508572800, 0)
+
+	page, err := parseReservePage(string(availableReserve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	remainCredit := page.formValues("").Get("remainCredit")
+	changed, err := page.setReservation(&availableDate, "userWeekReserves.selected0", 1)
+	if err != nil || !changed {
+		t.Fatalf("can't reserve food, %v", err)
+	}
+	form := page.formValues("method:doReserve")
+	if form.Get("userWeekReserves[0].selected") != "true" || form.Get("userWeekReserves[0].selectedCount") != "1" ||
+		len(form.Get("method:doReserve")) == 0 || form.Get("remainCredit") == remainCredit {
+		t.Errorf("reservation form doesn't reserve food, %v", form)
+	}
+	if changed, err := page.setReservation(&availableDate, "userWeekReserves.selected0", 1); err != nil || changed {
+		t.Errorf("reserving a reserved food changed page, %v", err)
+	}
+
+	tests := []struct {
+		page    []byte
+		date    time.Time
+		foodID string
+		count  int
+		err    error
+	}{
+		// Cancelling a food which isn't reserved
+		{availableReserve, availableDate, "userWeekReserves.selected0", 0, nil},
+		// Reserving a reserved food whose deadline is passed
+		{notavailableReserve, notavailableDate, "userWeekReserves.selected0", 1, nil},
+		{notavailableReserve, notavailableDate, "userWeekReserves.selected0", 0, ErrFoodUnavailable},
+		{notavailableReserve, notavailableDate, "userWeekReserves.selected1", 1, ErrFoodUnavailable},
+		{availableReserve, notavailableDate, "userWeekReserves.selected0", 1, ErrFoodNotFound},
+		// Samad only offers one portion
+		{availableReserve, availableDate, "userWeekReserves.selected0", 2, ErrCountNotAllowed},
+	}
+	for _, test := range tests {
+		page, err := parseReservePage(string(test.page))
+		if err != nil {
+			t.Fatal(err)
+		}
+		changed, err := page.setReservation(&test.date, test.foodID, test.count)
+		if err != test.err || changed {
+			t.Errorf("setReservation(%v, %v, %v) = %v, %v; want false, %v",
+				test.date, test.foodID, test.count, changed, err, test.err)
+		}
+	}
+}
+
+func TestParseReservePageErrors(t *testing.T) {
+	availableReserve, err := ioutil.ReadFile("../test/samad/reserve_available.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+
+	// Samad's layout has changed
+	brokenReserve := strings.Replace(string(availableReserve), "1396/08/07", "07 Aban 1396", -1)
+	brokenReserve = strings.Replace(brokenReserve, `<span id="creditId">`, `<span id="credit">`, -1)
+	page, err := parseReservePage(brokenReserve)
+	if _, ok := err.(ParseError); !ok || page == nil {
+		t.Fatalf("parseReservePage() returned %v, want ParseError", err)
+	}
+	if page.parseError("credit") == nil || page.parseError("date") == nil {
+		t.Errorf("credit and date errors aren't reported, %v", page.ParseErrors)
+	}
+	if len(page.Reserves) != 10 || len(page.Foods()) != 8 {
+		t.Errorf("found %v reserves and %v foods in partially parsed page, want 10 and 8",
+			len(page.Reserves), len(page.Foods()))
+	}
+
+	// Parser shouldn't panic on truncated pages
+	for i := 0; i < len(availableReserve); i += 997 {
+		parseReservePage(string(availableReserve[:i]))
+	}
+}
+
+func TestReservePageChangeSelfValues(t *testing.T) {
+	availableReserve, err := ioutil.ReadFile("../test/samad/reserve_available.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+	availableDate := time.Unix(1509177600, 0)
+
+	// First food of page is reserved
+	reservedPage := strings.Replace(string(availableReserve), `name="userWeekReserves[0].selected"`,
+		`name="userWeekReserves[0].selected" checked="checked"`, 1)
+	reservedPage = strings.Replace(reservedPage, `name="userWeekReserves[0].id" value=""`,
+		`name="userWeekReserves[0].id" value="8903111"`, 1)
+	page, err := parseReservePage(reservedPage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form, err := page.changeSelfValues(&availableDate, "userWeekReserves.selected0", "8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("selfChangeReserveId") != "8903111" || form.Get(newSelfField) != "8" ||
+		form.Get("weekStartDateTimeAjx") != form.Get("weekStartDateTime") || len(form.Get(changeSelfMethod)) == 0 {
+		t.Errorf("form doesn't change self of reservation, %v", form)
+	}
+	if reserve := page.findReserve(&availableDate, "userWeekReserves.selected0"); page.findSameReserve(reserve) != reserve {
+		t.Errorf("findSameReserve() didn't find reserve %+v", reserve)
+	}
+
+	if _, err := page.changeSelfValues(&availableDate, "userWeekReserves.selected0", "2"); err != ErrSelfNotFound {
+		t.Errorf("changing reservation to an unknown self returned %v, want ErrSelfNotFound", err)
+	}
+	if _, err := page.changeSelfValues(&availableDate, "userWeekReserves.selected1", "8"); err != ErrFoodNotReserved {
+		t.Errorf("changing self of a food which isn't reserved returned %v, want ErrFoodNotReserved", err)
+	}
+}
+
+func TestReservePageSetReservationCount(t *testing.T) {
+	availableReserve, err := ioutil.ReadFile("../test/samad/reserve_available.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+	availableDate := time.Unix(1509177600, 0)
+
+	// Samad allows reserving guest meals of first food
+	guestReserve := strings.Replace(string(availableReserve), `<option value="1">1</option>`,
+		`<option value="1">1</option><option value="2">2</option><option value="3">3</option>`, 1)
+	page, err := parseReservePage(guestReserve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reserve := page.findReserve(&availableDate, "userWeekReserves.selected0")
+	if reserve.MaxCount != 3 || reserve.Food.MaxCount != 3 {
+		t.Fatalf("MaxCount = %v, want 3", reserve.MaxCount)
+	}
+	price := reserve.Food.Price
+	remainCredit := page.remainCredit()
+	if price != model.Rials(12500) || remainCredit != model.Rials(-24549) {
+		t.Fatalf("price = %v, remainCredit = %v, want 12500 and -24549", price, remainCredit)
+	}
+
+	counts := []int{3, 1, 0}
+	for _, count := range counts {
+		if changed, err := page.setReservation(&availableDate, "userWeekReserves.selected0", count); err != nil || !changed {
+			t.Fatalf("can't reserve %v portions of food, %v", count, err)
+		}
+		form := page.formValues("method:doReserve")
+		if count > 0 && form.Get("userWeekReserves[0].selectedCount") != strconv.Itoa(count) {
+			t.Errorf("selectedCount = %v, want %v", form.Get("userWeekReserves[0].selectedCount"), count)
+		}
+		if form.Get("remainCredit") != strconv.Itoa((remainCredit - price.Times(count)).InRials()) {
+			t.Errorf("remainCredit = %v after reserving %v portions, want %v",
+				form.Get("remainCredit"), count, remainCredit-price.Times(count))
+		}
+	}
+	if _, err := page.setReservation(&availableDate, "userWeekReserves.selected0", 4); err != ErrCountNotAllowed {
+		t.Errorf("reserving 4 portions returned %v, want ErrCountNotAllowed", err)
+	}
+}
+
+func TestReservePageIsApplied(t *testing.T) {
+	notavailableReserve, err := ioutil.ReadFile("../test/samad/reserve_notavailable.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+	notavailableDate := time.Unix(1508572800, 0)
+
+	page, err := parseReservePage(string(notavailableReserve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		change  ReservationChange
+		applied bool
+	}{
+		{ReservationChange{Date: notavailableDate, FoodID: "userWeekReserves.selected0", Count: 1}, true},
+		{ReservationChange{Date: notavailableDate, FoodID: "userWeekReserves.selected0", Count: 2}, false},
+		{ReservationChange{Date: notavailableDate, FoodID: "userWeekReserves.selected0"}, false},
+		{ReservationChange{Date: notavailableDate, FoodID: "userWeekReserves.selected1"}, true},
+		{ReservationChange{Date: notavailableDate.AddDate(0, 0, 7), FoodID: "userWeekReserves.selected0"}, false},
+	}
+	for _, test := range tests {
+		if applied := page.isApplied(test.change); applied != test.applied {
+			t.Errorf("isApplied(%+v) = %v, want %v", test.change, applied, test.applied)
+		}
+	}
+
+	result := &ReservationResult{Applied: []ReservationChange{tests[0].change}}
+	if result.updateStatus(); result.Status != ReservationApplied {
+		t.Errorf("Status = %v, want ReservationApplied", result.Status)
+	}
+	result.Failed = []ReservationChange{tests[1].change}
+	if result.updateStatus(); result.Status != ReservationPartiallyApplied {
+		t.Errorf("Status = %v, want ReservationPartiallyApplied", result.Status)
+	}
+	result.Applied = nil
+	if result.updateStatus(); result.Status != ReservationRejected {
+		t.Errorf("Status = %v, want ReservationRejected", result.Status)
+	}
+}
+
+func TestReservePageSetDeadlines(t *testing.T) {
+	availableReserve, err := ioutil.ReadFile("../test/samad/reserve_available.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+	notavailableReserve, err := ioutil.ReadFile("../test/samad/reserve_notavailable.html")
+	if err != nil {
+		t.Fatalf("can't open test html, %v", err)
+	}
+
+	page, err := parseReservePage(string(availableReserve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	programDateTime := time.Unix(1509136200, 0)
+	now := programDateTime.Add(-48 * time.Hour)
+	page.setDeadlines(24*time.Hour, now)
+
+	food := page.Reserves[0].Food
+	if food.ProgramID != "987627" || food.MealTypeID != "2" || food.FoodTypeID != "516" || food.ReserveID != "" {
+		t.Errorf("metadata of food is %+v", food)
+	}
+	if food.ChangeableUntil == nil || !food.ChangeableUntil.Equal(programDateTime.Add(-24*time.Hour)) {
+		t.Errorf("ChangeableUntil = %v, want %v", food.ChangeableUntil, programDateTime.Add(-24*time.Hour))
+	}
+	if !food.IsChangeable(now) {
+		t.Error("food isn't changeable before its deadline")
+	}
+	if food.IsChangeable(programDateTime.Add(-time.Hour)) {
+		t.Error("food is changeable after its deadline")
+	}
+
+	page, err = parseReservePage(string(notavailableReserve))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page.setDeadlines(24*time.Hour, now)
+	food = page.Reserves[0].Food
+	if food.ReserveID != "8903111" {
+		t.Errorf("ReserveID = %v, want 8903111", food.ReserveID)
+	}
+	if food.IsChangeable(now) {
+		t.Error("food whose checkbox is disabled is changeable")
+	}
+}
@@ -0,0 +1,285 @@
+package selfservice
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/aryahadii/sarioself/model"
+	"github.com/aryahadii/sarioself/test/fakesamad"
+	"github.com/pkg/errors"
+)
+
+const (
+	fakeSamadUsername = "9531000"
+	fakeSamadPassword = "secret"
+)
+
+// fixedCaptchaSolver reads every captcha as its text
+type fixedCaptchaSolver string
+
+func (s fixedCaptchaSolver) Solve(captcha image.Image) (string, error) {
+	return string(s), nil
+}
+
+func newFakeSamad(t *testing.T) (*fakesamad.Server, SamadProvider) {
+	server, err := fakesamad.New(fakeSamadUsername, fakeSamadPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := SamadProvider{Name: "fake", BaseURL: server.BaseURL()}
+	return server, provider
+}
+
+func newFakeSamadClient(t *testing.T, provider SamadProvider) *SamadClient {
+	client, err := NewSamadClient(context.Background(), provider, fakeSamadUsername, fakeSamadPassword,
+		SamadConfig{CaptchaSolver: fixedCaptchaSolver(fakesamad.CaptchaAnswer)})
+	if err != nil {
+		t.Fatalf("can't login to fake Samad, %v", err)
+	}
+	return client
+}
+
+func TestSamadClientLogin(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	ctx := context.Background()
+
+	newFakeSamadClient(t, provider)
+
+	_, err := NewSamadClient(ctx, provider, fakeSamadUsername, "wrong",
+		SamadConfig{CaptchaSolver: fixedCaptchaSolver(fakesamad.CaptchaAnswer)})
+	if errors.Cause(err) != ErrInvalidCredentials {
+		t.Errorf("login with wrong password returned %v, want ErrInvalidCredentials", err)
+	}
+
+	captchas := server.Requests("/captcha.jpg")
+	_, err = NewSamadClient(ctx, provider, fakeSamadUsername, fakeSamadPassword,
+		SamadConfig{CaptchaSolver: fixedCaptchaSolver("00000"), CaptchaAttempts: 2})
+	if errors.Cause(err) != ErrWrongCaptcha {
+		t.Errorf("login with wrong captcha returned %v, want ErrWrongCaptcha", err)
+	}
+	if server.Requests("/captcha.jpg")-captchas != 2 {
+		t.Errorf("login got %v captchas, want 2", server.Requests("/captcha.jpg")-captchas)
+	}
+
+	_, err = NewSamadClient(ctx, provider, fakeSamadUsername, fakeSamadPassword,
+		SamadConfig{CaptchaSolver: fixedCaptchaSolver("00000"), ManualCaptcha: true})
+	captchaErr, ok := err.(*CaptchaRequiredError)
+	if !ok {
+		t.Fatalf("login with manual captcha returned %v, want CaptchaRequiredError", err)
+	}
+	if len(captchaErr.Challenge.CaptchaImage) == 0 {
+		t.Error("challenge has no captcha image")
+	}
+	if _, ok := captchaErr.Challenge.Solve(ctx, "00000").(*CaptchaRequiredError); !ok {
+		t.Error("wrong captcha of user didn't return a new challenge")
+	}
+	if err := captchaErr.Challenge.Solve(ctx, fakesamad.CaptchaAnswer); err != nil {
+		t.Errorf("captcha of user isn't accepted, %v", err)
+	}
+}
+
+func TestSamadClientReservations(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	ctx := context.Background()
+	client := newFakeSamadClient(t, provider)
+
+	selves, err := client.GetSelves(ctx)
+	if err != nil || len(selves) != 2 || selves[1].ID != "8" {
+		t.Fatalf("GetSelves() = %v, %v", selves, err)
+	}
+
+	foods, err := client.GetWeekFoods(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(foods) != 10 || WeekOf(*foods[0].Date) != 0 || foods[0].Status != model.FoodStatusReservable {
+		t.Fatalf("foods of this week aren't parsed correctly, %+v", foods)
+	}
+	food := foods[0]
+	credit := model.Rials(server.Credit())
+
+	result, err := client.Reserve(ctx, "1", food.Date, food.ID, 1)
+	if err != nil || result.Status != ReservationApplied {
+		t.Fatalf("Reserve() = %+v, %v", result, err)
+	}
+	if result.Credit != credit-food.Price || model.Rials(server.Credit()) != result.Credit {
+		t.Errorf("credit is %v after reserve, want %v", result.Credit, credit-food.Price)
+	}
+	if server.Reserved("1", 0, 0) != 1 {
+		t.Errorf("fake Samad has %v portions of food, want 1", server.Reserved("1", 0, 0))
+	}
+
+	result, err = client.Cancel(ctx, "1", food.Date, food.ID)
+	if err != nil || result.Status != ReservationApplied || result.Credit != credit {
+		t.Fatalf("Cancel() = %+v, %v", result, err)
+	}
+	if server.Reserved("1", 0, 0) != 0 {
+		t.Errorf("food is reserved after cancel")
+	}
+
+	// Changes of two weeks of another self
+	nextWeekFoods, err := client.GetWeekFoods(ctx, "8", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if WeekOf(*nextWeekFoods[0].Date) != 1 || nextWeekFoods[0].SelfID != "8" {
+		t.Fatalf("foods of next week of self 8 aren't parsed correctly, %+v", nextWeekFoods[0])
+	}
+	result, err = client.ApplyReservations(ctx, "8", []ReservationChange{
+		{Date: *nextWeekFoods[0].Date, FoodID: nextWeekFoods[0].ID, Count: 1},
+		{Date: *food.Date, FoodID: food.ID, Count: 1},
+	})
+	if err != nil || result.Status != ReservationApplied || len(result.Applied) != 2 {
+		t.Fatalf("ApplyReservations() = %+v, %v", result, err)
+	}
+	if server.Reserved("8", 0, 0) != 1 || server.Reserved("8", 1, 0) != 1 || server.Reserved("1", 0, 0) != 0 {
+		t.Error("reservations of self 8 aren't applied to fake Samad")
+	}
+	reservations, err := client.GetReservations(ctx)
+	if err != nil || len(reservations) != 2 {
+		t.Errorf("GetReservations() = %v, %v", reservations, err)
+	}
+
+	// Portions
+	server.MaxCount = 3
+	result, err = client.Reserve(ctx, "1", food.Date, food.ID, 3)
+	if err != nil || result.Status != ReservationApplied || server.Reserved("1", 0, 0) != 3 {
+		t.Errorf("Reserve() of 3 portions = %+v, %v", result, err)
+	}
+
+	// Samad rejects reservations without enough credit
+	server.MinCredit = server.Credit()
+	result, err = client.Reserve(ctx, "1", foods[2].Date, foods[2].ID, 1)
+	if err != nil || result.Status != ReservationRejected || len(result.Reason) == 0 {
+		t.Errorf("Reserve() without credit = %+v, %v", result, err)
+	}
+	if server.Reserved("1", 0, 2) != 0 {
+		t.Error("food is reserved without credit")
+	}
+
+	// Past weeks are locked
+	pastFoods, err := client.GetWeekFoods(ctx, "", -1)
+	if err != nil || len(pastFoods) == 0 || WeekOf(*pastFoods[0].Date) != -1 {
+		t.Fatalf("GetWeekFoods() of last week = %v, %v", pastFoods, err)
+	}
+	if pastFoods[0].IsChangeable(*pastFoods[0].Date) {
+		t.Error("food of last week is changeable")
+	}
+	if _, err := client.Cancel(ctx, "", pastFoods[0].Date, pastFoods[0].ID); err != ErrFoodUnavailable {
+		t.Errorf("Cancel() of last week returned %v, want ErrFoodUnavailable", err)
+	}
+}
+
+func TestSamadClientAvailableFoods(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	ctx := context.Background()
+	client := newFakeSamadClient(t, provider)
+
+	foods, err := client.GetWeekFoods(ctx, "1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	availableFoods, err := client.GetAvailableFoods(ctx, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lunch of each day of reserve_available.html has two foods
+	var lunch []*model.Food
+	for date, dateFoods := range availableFoods {
+		if date.Equal(*foods[0].Date) {
+			lunch = dateFoods
+		}
+	}
+	if len(lunch) != 2 || lunch[0].ID != foods[0].ID || lunch[1].ID != foods[1].ID {
+		t.Errorf("GetAvailableFoods() returned %v foods for lunch, want %v and %v", lunch, foods[0], foods[1])
+	}
+}
+
+func TestSamadClientReserveAlternativeFood(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	ctx := context.Background()
+	client := newFakeSamadClient(t, provider)
+
+	foods, err := client.GetWeekFoods(ctx, "1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first two foods are alternatives of lunch of the same day
+	food, alternative := foods[0], foods[1]
+	if !food.Date.Equal(*alternative.Date) || food.MealTime != alternative.MealTime {
+		t.Fatalf("foods %+v and %+v aren't alternatives", food, alternative)
+	}
+	credit := model.Rials(server.Credit())
+
+	if result, err := client.Reserve(ctx, "1", food.Date, food.ID, 1); err != nil || result.Status != ReservationApplied {
+		t.Fatalf("Reserve() = %+v, %v", result, err)
+	}
+	result, err := client.Reserve(ctx, "1", alternative.Date, alternative.ID, 1)
+	if err != nil || result.Status != ReservationApplied {
+		t.Fatalf("Reserve() of alternative food = %+v, %v", result, err)
+	}
+	if server.Reserved("1", 0, 0) != 0 || server.Reserved("1", 0, 1) != 1 {
+		t.Errorf("fake Samad has %v and %v portions of foods, want 0 and 1",
+			server.Reserved("1", 0, 0), server.Reserved("1", 0, 1))
+	}
+	if result.Credit != credit-alternative.Price || model.Rials(server.Credit()) != result.Credit {
+		t.Errorf("credit is %v after reserving alternative food, want %v", result.Credit, credit-alternative.Price)
+	}
+}
+
+func TestSamadClientChangeSelf(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	ctx := context.Background()
+	client := newFakeSamadClient(t, provider)
+
+	foods, err := client.GetWeekFoods(ctx, "1", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Reserve(ctx, "1", foods[0].Date, foods[0].ID, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Guesses of change self form aren't sent to Samad
+	requests := server.Requests(client.provider.Paths.Reservation)
+	if err := client.ChangeSelf(ctx, "1", foods[0].Date, foods[0].ID, "8"); err != ErrChangeSelfUnsupported {
+		t.Errorf("ChangeSelf() returned %v, want ErrChangeSelfUnsupported", err)
+	}
+	if server.Requests(client.provider.Paths.Reservation) != requests {
+		t.Error("ChangeSelf() sent a request to Samad")
+	}
+
+	// Fake Samad ignores change of self like Samad would ignore a wrong
+	// guess of its form
+	if err := client.changeSelf(ctx, "1", foods[0].Date, foods[0].ID, "8"); err != ErrSelfChangeFailed {
+		t.Errorf("changeSelf() which isn't applied returned %v, want ErrSelfChangeFailed", err)
+	}
+	if server.Reserved("1", 1, 0) != 1 || server.Reserved("8", 1, 0) != 0 {
+		t.Error("reservation isn't kept in its self")
+	}
+}
+
+func TestSamadClientSessionExpiry(t *testing.T) {
+	server, provider := newFakeSamad(t)
+	defer server.Close()
+	client := newFakeSamadClient(t, provider)
+
+	server.ExpireSessions()
+	logins := server.Requests("/j_security_check")
+	credit, err := client.GetCredit(context.Background())
+	if err != nil {
+		t.Fatalf("GetCredit() after expiry of session failed, %v", err)
+	}
+	if credit != model.Rials(server.Credit()) {
+		t.Errorf("GetCredit() = %v, want %v", credit, server.Credit())
+	}
+	if server.Requests("/j_security_check") != logins+1 {
+		t.Errorf("client logged in %v times after expiry, want 1", server.Requests("/j_security_check")-logins)
+	}
+}
@@ -1,6 +1,7 @@
 package selfservice
 
 import (
+	"context"
 	"net/http/cookiejar"
 	"time"
 
@@ -16,6 +17,77 @@ type userSessionData struct {
 
 // Client is interface for clients of restaurants
 type Client interface {
-	GetAvailableFoods() (map[time.Time][]*model.Food, error)
-	ReserveFood(date *time.Time, foodID string) error
+	// GetSelves returns restaurants which user can reserve food from
+	GetSelves(ctx context.Context) ([]Self, error)
+	// GetAvailableFoods returns foods of self which can be reserved, keyed
+	// by their meal time. Empty selfID is the self which service chooses.
+	GetAvailableFoods(ctx context.Context, selfID string) (map[time.Time][]*model.Food, error)
+	// GetReservations returns foods which are reserved by user in all selves
+	GetReservations(ctx context.Context) ([]*model.Food, error)
+	// GetWeekFoods returns all foods of self in week, which is relative to
+	// the current week, e.g. -1 is the previous week and 1 is the next one
+	GetWeekFoods(ctx context.Context, selfID string, week int) ([]*model.Food, error)
+	// Reserve reserves count portions of food of date in self, reserving a
+	// reserved food with the same count is a no-op
+	Reserve(ctx context.Context, selfID string, date *time.Time, foodID string, count int) (*ReservationResult, error)
+	// Cancel cancels reservation of food of date in self, cancelling a food
+	// which isn't reserved is a no-op
+	Cancel(ctx context.Context, selfID string, date *time.Time, foodID string) (*ReservationResult, error)
+	// ApplyReservations reserves or cancels foods of self, changes of a week
+	// are submitted together
+	ApplyReservations(ctx context.Context, selfID string, changes []ReservationChange) (*ReservationResult, error)
+	// ChangeSelf moves reservation of food of date from self to newSelfID
+	// without cancelling it, it returns ErrChangeSelfUnsupported if
+	// reservation service can't do it
+	ChangeSelf(ctx context.Context, selfID string, date *time.Time, foodID, newSelfID string) error
+	// GetCredit returns credit of user
+	GetCredit(ctx context.Context) (model.Money, error)
+}
+
+// ReservationChange is a change in reservation of a food, zero Count cancels
+// reservation of food
+type ReservationChange struct {
+	Date   time.Time
+	FoodID string
+	Count  int
+}
+
+// ReservationStatus is the outcome of submitting reservation changes
+type ReservationStatus int
+
+const (
+	// ReservationApplied means all of changes are applied
+	ReservationApplied ReservationStatus = iota
+	// ReservationPartiallyApplied means some of changes aren't applied
+	ReservationPartiallyApplied
+	// ReservationRejected means none of changes are applied
+	ReservationRejected
+)
+
+// ReservationResult is the result of reservation changes, which is checked
+// on reservation service after they're submitted
+type ReservationResult struct {
+	Status ReservationStatus
+	// Reason is the message of reservation service about changes which
+	// aren't applied, it can be empty
+	Reason string
+	// Applied are changes which are in effect, including changes which
+	// were in effect before submit
+	Applied []ReservationChange
+	// Failed are changes which aren't in effect
+	Failed []ReservationChange
+	// Credit is remaining credit of user
+	Credit model.Money
+}
+
+// updateStatus sets status of result from its applied and failed changes
+func (r *ReservationResult) updateStatus() {
+	switch {
+	case len(r.Failed) == 0:
+		r.Status = ReservationApplied
+	case len(r.Applied) == 0:
+		r.Status = ReservationRejected
+	default:
+		r.Status = ReservationPartiallyApplied
+	}
 }
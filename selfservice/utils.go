@@ -1,10 +1,11 @@
 package selfservice
 
 import (
+	"context"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -12,188 +13,183 @@ import (
 	"github.com/PuerkitoBio/goquery"
 	"github.com/aryahadii/sarioself/model"
 	"github.com/pkg/errors"
-	"github.com/yaa110/go-persian-calendar/ptime"
+	"github.com/sirupsen/logrus"
 )
 
-func (s *SamadAUTClient) getSamadReservePage() (string, error) {
-	var bodyString string
+// getSamadReservePage returns reservation page of Samad, it logs in again
+// if session of client is expired
+func (s *SamadClient) getSamadReservePage(ctx context.Context) (*ReservePage, error) {
+	page, err := s.fetchSamadReservePage(ctx)
+	if err == ErrSessionExpired {
+		s.loggedIn = false
+		logrus.WithField("username", s.sessionData.username).
+			Infoln("Samad session is expired, logging in again")
+		if err = s.logIn(ctx); err != nil {
+			return nil, errors.Wrap(err, "can't renew Samad session")
+		}
+		page, err = s.fetchSamadReservePage(ctx)
+	}
+	return page, err
+}
 
-	response, err := s.httpClient.Get(samadReservationURL)
+func (s *SamadClient) fetchSamadReservePage(ctx context.Context) (*ReservePage, error) {
+	response, err := s.get(ctx, s.provider.reservationURL())
 	if err != nil {
-		return bodyString, err
-	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return bodyString, fmt.Errorf("Samad returned %v status code when tried to serve reservation page ",
-			response.StatusCode)
+		return nil, err
 	}
+	defer closeResponse(response)
 	body, _ := ioutil.ReadAll(response.Body)
-	bodyString = string(body)
-	s.sessionData.csrf = csrfRegex.FindStringSubmatch(bodyString)[1]
-	io.Copy(ioutil.Discard, response.Body)
-	response.Body.Close()
+	bodyString := string(body)
+	if isLoginPage(response, bodyString) {
+		return nil, ErrSessionExpired
+	}
 
-	return bodyString, nil
+	return s.parseReservePage(bodyString)
 }
 
-func (s *SamadAUTClient) getNextSamadReservePage(bodyString string) (string, error) {
-	var nextBodyString string
-
-	formValues, err := extractFormInputValues(bodyString)
-	if err != nil {
-		return nextBodyString, errors.Wrap(err, "can't extract form input values")
-	}
-	formValues.Set("method:showNextWeek", "Submit")
-	request, err := http.NewRequest("POST", samadReservationActionURL, strings.NewReader(formValues.Encode()))
-	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	request.Header.Set("X-Csrf-Token", s.sessionData.csrf)
-	response, err := s.httpClient.Do(request)
-	defer func() {
-		io.Copy(ioutil.Discard, response.Body)
-		response.Body.Close()
-	}()
-	if err != nil {
-		return nextBodyString, errors.Wrap(err, "can't read second page of Samad")
+// parseReservePage parses a reservation page and keeps its CSRF token for
+// next requests
+func (s *SamadClient) parseReservePage(bodyString string) (*ReservePage, error) {
+	page, err := parseReservePage(bodyString)
+	if page == nil {
+		return nil, errors.Wrap(err, "can't parse reservation page")
 	}
-	if response.StatusCode < 200 || response.StatusCode >= 300 {
-		return nextBodyString, fmt.Errorf("Samad returned %v status code when tried to serve second reservation page ",
-			response.StatusCode)
+	if len(page.ParseErrors) > 0 {
+		s.reportParseFailure(bodyString, page.ParseErrors...)
 	}
-	body, _ := ioutil.ReadAll(response.Body)
-	nextBodyString = string(body)
-	s.sessionData.csrf = csrfRegex.FindStringSubmatch(bodyString)[1]
+	page.setServingWindows(s.provider.servingWindows)
+	page.setDeadlines(s.provider.reserveDeadline(), time.Now())
+	if len(page.csrf) > 0 {
+		s.sessionData.csrf = page.csrf
+	}
+	if len(page.Selves) > 0 {
+		s.selves = page.Selves
+	}
+	return page, nil
+}
+
+// isLoginPage checks whether Samad has redirected request to its login page,
+// which happens when session is expired
+func isLoginPage(response *http.Response, bodyString string) bool {
+	if response.Request != nil && strings.HasSuffix(response.Request.URL.Path, "/loginpage.rose") {
+		return true
+	}
+	return strings.Contains(bodyString, "j_security_check")
+}
 
-	return nextBodyString, nil
+// getNextSamadReservePage returns reservation page of the week after page
+func (s *SamadClient) getNextSamadReservePage(ctx context.Context, page *ReservePage) (*ReservePage, error) {
+	return s.submitReservePage(ctx, page, "method:showNextWeek")
 }
 
-func (s *SamadAUTClient) toggleFoodReservation(samadPage string, date *time.Time, foodID string) (bool, error) {
-	var toggled bool
+// submitReservePage posts form of page using method and returns the page
+// which Samad responds with
+func (s *SamadClient) submitReservePage(ctx context.Context, page *ReservePage, method string) (*ReservePage, error) {
+	return s.postReservePage(ctx, page.formValues(method))
+}
 
-	document, err := goquery.NewDocumentFromReader(strings.NewReader(samadPage))
+// postReservePage posts values of reservation form and returns the page which
+// Samad responds with
+func (s *SamadClient) postReservePage(ctx context.Context, values *url.Values) (*ReservePage, error) {
+	response, err := s.post(ctx, s.provider.reservationURL(), values)
 	if err != nil {
-		return toggled, errors.Wrap(err, "can't init goquery on document")
-	}
-
-	document.Find(":input[type=checkbox]").Each(func(i int, s *goquery.Selection) {
-		food := makeFoodObject(s)
-
-		if food.Date.Equal(*date) && food.ID == foodID {
-			if _, ok := s.Attr("checked"); ok {
-				s.RemoveAttr("checked")
-
-				document.Find(":input[name=remainCredit]").Each(func(i int, s *goquery.Selection) {
-					currentCredit, _ := s.Attr("value")
-					intCredit, _ := strconv.Atoi(currentCredit)
-					s.SetAttr("value", strconv.Itoa(intCredit+food.PriceTooman))
-					toggled = true
-				})
-
-				s.Parent().Siblings().Children().First().SetAttr("value", "0")
-				s.Parent().Siblings().Children().Find(":select").SetAttr("disabled", "true")
-			} else {
-				if _, ok := s.Attr("disabled"); !ok {
-					s.SetAttr("checked", "true")
-
-					document.Find(":input[name=remainCredit]").Each(func(i int, s *goquery.Selection) {
-						currentCredit, _ := s.Attr("value")
-						intCredit, _ := strconv.Atoi(currentCredit)
-						s.SetAttr("value", strconv.Itoa(intCredit-food.PriceTooman))
-						toggled = true
-					})
-
-					s.Parent().Siblings().Children().First().SetAttr("value", "1")
-					s.Parent().Siblings().Children().Find(":select").RemoveAttr("disabled")
-					s.Parent().Siblings().Children().Find(":option").SetAttr("selected", "true")
-				}
-			}
-		}
-	})
+		return nil, err
+	}
+	defer closeResponse(response)
+	body, _ := ioutil.ReadAll(response.Body)
+	bodyString := string(body)
+	if isLoginPage(response, bodyString) {
+		return nil, ErrSessionExpired
+	}
 
-	if toggled {
-		htmlString, _ := document.Html()
-		formValues, err := extractFormInputValues(htmlString)
-		if err != nil {
-			return toggled, errors.Wrap(err, "can't extract form input values")
-		}
-		formValues.Set("method:doReserve", "Submit")
-		request, err := http.NewRequest("POST", samadReservationActionURL, strings.NewReader(formValues.Encode()))
-		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		request.Header.Set("X-Csrf-Token", s.sessionData.csrf)
-		response, err := s.httpClient.Do(request)
-		defer func() {
-			io.Copy(ioutil.Discard, response.Body)
-			response.Body.Close()
-		}()
-
-		if err = getErrorOnPage(response.Body); err != nil {
-			if samadError, ok := err.(SamadError); ok {
-				return toggled, samadError
-			}
-			return toggled, errors.Wrap(err, "can't check for error after reservation")
-		}
+	return s.parseReservePage(bodyString)
+}
+
+// submitReservation submits reservations of page and returns the error
+// message which Samad shows after it
+func (s *SamadClient) submitReservation(ctx context.Context, page *ReservePage) (string, error) {
+	resultPage, err := s.submitReservePage(ctx, page, "method:doReserve")
+	if err != nil {
+		return "", errors.Wrap(err, "can't send reservation to Samad")
 	}
-	return toggled, nil
+	return resultPage.Error, nil
 }
 
-func getErrorOnPage(page io.Reader) error {
-	document, err := goquery.NewDocumentFromReader(page)
+// getLoginError finds out why Samad has rejected login from the login page
+// it has returned
+func getLoginError(loginPage string) error {
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(loginPage))
 	if err != nil {
 		return errors.Wrap(err, "can't init goquery on document")
 	}
 
-	var samadError error
-	document.Find("#errorMessages").Each(func(i int, s *goquery.Selection) {
-		if len(s.Text()) > 0 {
-			samadError = SamadError{
-				What: s.Text(),
-				When: time.Now(),
+	message := strings.TrimSpace(document.Find(loginErrorSelector).Text())
+	if len(message) == 0 {
+		return fmt.Errorf("Samad didn't accept login")
+	}
+	for _, loginError := range loginErrorPhrases {
+		for _, phrase := range loginError.phrases {
+			if strings.Contains(message, phrase) {
+				return loginError.err
 			}
 		}
-	})
-	return samadError
-}
-
-func getMealTimeLunch(year, month, day int) *time.Time {
-	jalaliDate := ptime.Date(year, ptime.Month(month), day, 10, 0, 0, 0, ptime.Iran())
-	gregorianDate := jalaliDate.Time()
-	return &gregorianDate
-}
-
-func getMealDate(year, month, day int, mealTime model.MealTime) *time.Time {
-	var jalaliDate ptime.Time
-	if mealTime == model.MealTimeLunch {
-		jalaliDate = ptime.Date(year, ptime.Month(month), day, 11, 30, 0, 0, ptime.Iran())
-	} else {
-		jalaliDate = ptime.Date(year, ptime.Month(month), day, 19, 0, 0, 0, ptime.Iran())
 	}
-	gregorianDate := jalaliDate.Time()
-	return &gregorianDate
+	return SamadError{
+		What: message,
+		When: time.Now(),
+	}
 }
 
-func makeFoodObject(s *goquery.Selection) *model.Food {
+// makeFoodObject creates food from its checkbox in reservation form. If a
+// field can't be parsed, the food is returned with the fields which are
+// parsed and a ParseError of the first failed field.
+func makeFoodObject(s *goquery.Selection, mealTimes map[string]model.MealTime) (*model.Food, error) {
 	food := &model.Food{}
+	var parseErr error
+	fail := func(err ParseError) {
+		if parseErr == nil {
+			parseErr = err
+		}
+	}
 
 	// Extract meal time
-	food.MealTime = model.MealTime(s.ParentsFiltered("table[align=\"center\"]").Parent().Index())
+	mealTypeID := s.AttrOr("mealtypeid", "")
+	if mealTime, err := parseMealTypeID(mealTypeID, mealTimes); err != nil {
+		fail(ParseError{Field: "meal type", Selector: "input[type=checkbox][mealtypeid]", Value: mealTypeID, Err: err})
+	} else {
+		food.MealTime = mealTime
+	}
 
-	// Extract date
-	foodDate := s.ParentsFiltered("table[align=\"center\"]").Parent().
-		SiblingsFiltered("td[valign=\"middle\"]").ChildrenFiltered("div").Text()
-	year, _ := strconv.Atoi(foodDate[:4])
-	month, _ := strconv.Atoi(foodDate[5:7])
-	day, _ := strconv.Atoi(foodDate[8:10])
-	food.Date = getMealDate(year, month, day, food.MealTime)
+	// Extract date, it's set to serving window of meal in default selves
+	mealTable := s.ParentsFiltered(mealTableSelector)
+	foodDate := strings.TrimSpace(mealTable.Parent().SiblingsFiltered(mealDateSelector).ChildrenFiltered("div").Text())
+	if year, month, day, err := parseJalaliDate(foodDate); err != nil {
+		fail(ParseError{Field: "date", Selector: mealDateSelector + " > div", Value: foodDate, Err: err})
+	} else {
+		start, end := defaultServingWindows.window(food.MealTime).on(year, month, day)
+		food.Date, food.ServedUntil = &start, &end
+	}
 
 	// Extract descriptions
 	foodDesc := strings.Split(strings.TrimSpace(s.SiblingsFiltered("span").Text()), " | ")
-	food.Name = foodDesc[1]
+	if len(foodDesc) < 2 {
+		fail(ParseError{Field: "name", Selector: "span", Value: strings.Join(foodDesc, " | ")})
+	} else {
+		food.Name = foodDesc[1]
+	}
 	if len(foodDesc) > 2 {
 		food.SideDish = foodDesc[2]
 	}
 
 	// Extract price
-	stringPrice := strings.Split(strings.TrimSpace(s.SiblingsFiltered("div").Text()), " ")[0]
-	intPrice, _ := strconv.Atoi(stringPrice)
-	food.PriceTooman = intPrice
+	priceText := strings.TrimSpace(s.SiblingsFiltered("div").Text())
+	if fields := strings.Fields(priceText); len(fields) == 0 {
+		fail(ParseError{Field: "price", Selector: "div"})
+	} else if price, err := strconv.Atoi(fields[0]); err != nil {
+		fail(ParseError{Field: "price", Selector: "div", Value: priceText, Err: err})
+	} else {
+		food.Price = model.Rials(price)
+	}
 
 	// Extract status
 	if _, ok := s.Attr("disabled"); ok {
@@ -205,7 +201,58 @@ func makeFoodObject(s *goquery.Selection) *model.Food {
 	}
 
 	// FoodID
-	food.ID, _ = s.Attr("id")
+	var ok bool
+	if food.ID, ok = s.Attr("id"); !ok {
+		fail(ParseError{Field: "food ID", Selector: "input[type=checkbox][id]"})
+	}
 
-	return food
+	return food, parseErr
+}
+
+var (
+	// mealTimeNames are names of meals in headers of meal table
+	mealTimeNames = map[string]model.MealTime{
+		"صبحانه": model.MealTimeBreakfast,
+		"ناهار":  model.MealTimeLunch,
+		"شام":    model.MealTimeDinner,
+	}
+
+	// defaultMealTimes maps mealTypeId of foods to meal times like headers of
+	// meal table of AUT's Samad (test/samad) do, it's used for meal types
+	// which page has no header for
+	defaultMealTimes = map[string]model.MealTime{
+		"1": model.MealTimeBreakfast,
+		"2": model.MealTimeLunch,
+		"3": model.MealTimeDinner,
+	}
+)
+
+// parseMealTypeID maps mealTypeId of Samad's form to meal time using
+// mealTimes of page
+func parseMealTypeID(mealTypeID string, mealTimes map[string]model.MealTime) (model.MealTime, error) {
+	if mealTime, ok := mealTimes[mealTypeID]; ok {
+		return mealTime, nil
+	}
+	return 0, fmt.Errorf("meal type %q is unknown", mealTypeID)
+}
+
+// parseJalaliDate parses dates like 1396/07/29
+func parseJalaliDate(date string) (year, month, day int, err error) {
+	parts := strings.Split(date, "/")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("date isn't in year/month/day format")
+	}
+	if year, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, 0, err
+	}
+	if month, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, 0, err
+	}
+	if day, err = strconv.Atoi(parts[2]); err != nil {
+		return 0, 0, 0, err
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return 0, 0, 0, fmt.Errorf("date is out of range")
+	}
+	return year, month, day, nil
 }
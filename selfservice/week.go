@@ -0,0 +1,34 @@
+package selfservice
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/yaa110/go-persian-calendar/ptime"
+)
+
+// WeekStart returns start of the week containing date, weeks of Samad start
+// on Saturday in Iran
+func WeekStart(date time.Time) time.Time {
+	date = date.In(ptime.Iran())
+	daysSinceSaturday := (int(date.Weekday()) + 1) % 7
+	return time.Date(date.Year(), date.Month(), date.Day()-daysSinceSaturday, 0, 0, 0, 0, date.Location())
+}
+
+// WeekOf returns week of date relative to the current week, e.g. -1 is the
+// previous week and 1 is the next one
+func WeekOf(date time.Time) int {
+	weeks := WeekStart(date).Sub(WeekStart(time.Now())).Hours() / (7 * 24)
+	// Weeks with daylight saving changes aren't exactly 7 days
+	return int(math.Floor(weeks + 0.5))
+}
+
+// ParseJalaliDate parses dates like 1396/07/29 to start of that day in Iran
+func ParseJalaliDate(date string) (time.Time, error) {
+	year, month, day, err := parseJalaliDate(strings.TrimSpace(date))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ptime.Date(year, ptime.Month(month), day, 0, 0, 0, 0, ptime.Iran()).Time(), nil
+}
@@ -0,0 +1,60 @@
+package selfservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yaa110/go-persian-calendar/ptime"
+)
+
+func TestWeekStart(t *testing.T) {
+	saturday := time.Date(2017, time.October, 28, 0, 0, 0, 0, ptime.Iran())
+	dates := []time.Time{
+		saturday,
+		saturday.Add(11*time.Hour + 30*time.Minute),
+		saturday.AddDate(0, 0, 6).Add(19 * time.Hour),
+		// Friday night in UTC is already Saturday in Iran
+		time.Date(2017, time.November, 3, 21, 0, 0, 0, time.UTC),
+	}
+	expected := []time.Time{saturday, saturday, saturday, saturday.AddDate(0, 0, 7)}
+	for i, date := range dates {
+		if start := WeekStart(date); !start.Equal(expected[i]) {
+			t.Errorf("week of %v starts at %v, expected %v", date, start, expected[i])
+		}
+	}
+
+	// weekStartDateTime of Samad's reserve_available page
+	samadWeekStart, _ := parseMillis("1509136200000")
+	if start := WeekStart(samadWeekStart); !start.Equal(samadWeekStart) {
+		t.Errorf("Samad week starts at %v, expected %v", samadWeekStart, start)
+	}
+}
+
+func TestWeekOf(t *testing.T) {
+	now := time.Now()
+	for _, week := range []int{-30, -1, 0, 1, 2, 30} {
+		if got := WeekOf(now.AddDate(0, 0, 7*week)); got != week {
+			t.Errorf("WeekOf returned %v, expected %v", got, week)
+		}
+	}
+	if week := WeekOf(WeekStart(now).AddDate(0, 0, 6)); week != 0 {
+		t.Errorf("last day of this week is in week %v", week)
+	}
+}
+
+func TestParseJalaliDate(t *testing.T) {
+	date, err := ParseJalaliDate(" 1396/08/06 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := time.Date(2017, time.October, 28, 0, 0, 0, 0, ptime.Iran())
+	if !date.Equal(expected) {
+		t.Errorf("parsed date is %v, expected %v", date, expected)
+	}
+
+	for _, invalid := range []string{"", "1396/08", "1396/13/01", "next week"} {
+		if _, err := ParseJalaliDate(invalid); err == nil {
+			t.Errorf("%q is parsed as a date", invalid)
+		}
+	}
+}
@@ -1,36 +1,116 @@
 package telegram
 
 import (
+	"time"
+
 	"github.com/aryahadii/miyanbor"
 	"github.com/aryahadii/sarioself/configuration"
+	"github.com/aryahadii/sarioself/selfservice"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	Bot *miyanbor.Bot
+
+	defaultReservationService string
+	requestTimeout            time.Duration
+	admins                    []int64
 )
 
 const (
-	foodReservePattern = `RES#(?P<weekday>[\w.]+)#(?P<foodid>\d+)`
+	foodReservePattern = `RES#(?P<weekday>[\w.]+)#(?P<foodid>\d+)(?:#(?P<self>\w*))?(?:#(?P<count>\d+))?`
+	foodCancelPattern  = `CNL#(?P<weekday>[\w.]+)#(?P<foodid>\d+)(?:#(?P<self>\w*))?`
+	weekMenuPattern    = `WEEK#(?P<week>-?\d+)(?:#(?P<self>\w*))?`
+	selfMenuPattern    = `MENU#(?P<self>\w*)`
+	defaultSelfPattern = `DEFSELF#(?P<self>\w+)`
+	changeSelfPattern  = `CHS#(?P<foodid>[\w.]+)#(?P<time>\d+)#(?P<self>\w*)`
+	newSelfPattern     = `MOV#(?P<foodid>[\w.]+)#(?P<time>\d+)#(?P<self>\w*)#(?P<newself>\w+)`
+	foodCountPattern   = `QTY#(?P<foodid>[\w.]+)#(?P<time>\d+)#(?P<self>\w*)#(?P<maxcount>\d+)`
+
+	startSelectionPattern   = `^BATCH$`
+	selectFoodPattern       = `^TCK#(?P<index>\d+)$`
+	confirmSelectionPattern = `^CFM$`
+	cancelSelectionPattern  = `^BCL$`
 )
 
 // StartBot makes telegram bot ready and starts it's updater
 func StartBot() {
 	logrus.Infof("Telegram bot is going to start")
 
+	if err := setupBot(); err != nil {
+		logrus.Fatalln(err)
+	}
+	updaterTimeout := configuration.SarioselfConfig.GetInt("bots.telegram.updater-timeout")
+	Bot.StartUpdater(0, updaterTimeout)
+}
+
+// setupBot creates Bot and registers reservation services which are defined
+// in config
+func setupBot() error {
 	token := configuration.SarioselfConfig.GetString("bots.telegram.token")
 	debug := (configuration.SarioselfConfig.GetBool("bots.telegram.debug") &&
 		configuration.SarioselfConfig.GetBool("bots.telegram.debug"))
 	sessionTimeout := configuration.SarioselfConfig.GetInt("bots.telegram.session-timeout")
-	updaterTimeout := configuration.SarioselfConfig.GetInt("bots.telegram.updater-timeout")
+	requestTimeout = time.Duration(configuration.SarioselfConfig.GetInt("bots.telegram.request-timeout")) * time.Second
+	if err := configuration.SarioselfConfig.UnmarshalKey("bots.telegram.admins", &admins); err != nil {
+		return err
+	}
+	samadSessionTimeout := configuration.SarioselfConfig.GetInt("samad.session-timeout")
+
+	manualCaptcha := configuration.SarioselfConfig.GetBool("samad.manual-captcha")
+
+	captchaSolver, err := selfservice.NewCaptchaSolver(configuration.SarioselfConfig.GetString("samad.captcha-solver"),
+		selfservice.LoadCaptchaSolverConfig(configuration.SarioselfConfig))
+	if err != nil {
+		if !manualCaptcha {
+			return err
+		}
+		logrus.WithError(err).Warnln("captchas should be solved by users")
+	}
+	samadConfig := selfservice.SamadConfig{
+		CaptchaAttempts: configuration.SarioselfConfig.GetInt("samad.captcha-attempts"),
+		CaptchaSolver:   captchaSolver,
+		ManualCaptcha:   manualCaptcha,
+		RequestTimeout:  time.Duration(configuration.SarioselfConfig.GetInt("samad.request-timeout")) * time.Second,
+		Retries:         configuration.SarioselfConfig.GetInt("samad.retries"),
+		RetryBackoff:    time.Duration(configuration.SarioselfConfig.GetInt("samad.retry-backoff")) * time.Millisecond,
+		Diagnostics:     newDiagnostics(),
+	}
+
+	samadClients := selfservice.NewClientPool(time.Duration(samadSessionTimeout)*time.Minute, samadConfig)
+
+	samadProviders, err := selfservice.LoadSamadProviders(configuration.SarioselfConfig)
+	if err != nil {
+		return err
+	}
+	if err := selfservice.RegisterSamadProviders(samadProviders, samadClients); err != nil {
+		return err
+	}
+	defaultReservationService = configuration.SarioselfConfig.GetString("samad.default-provider")
+	if !isReservationService(defaultReservationService) {
+		return errors.Errorf("default reservation service %v isn't registered", defaultReservationService)
+	}
 
-	var err error
 	Bot, err = miyanbor.NewBot(token, debug, sessionTimeout)
 	if err != nil {
-		logrus.Fatalln(err)
+		return err
 	}
 	setCallbacks(Bot)
-	Bot.StartUpdater(0, updaterTimeout)
+	return nil
+}
+
+// loadSamadProviders loads Samad instances which are defined in config, AUT's
+// Samad is used if there isn't any
+// newDiagnostics creates Diagnostics which saves Samad pages that can't be
+// parsed and alerts admins, it returns nil if diagnostics dir isn't set
+func newDiagnostics() *selfservice.Diagnostics {
+	dir := configuration.SarioselfConfig.GetString("diagnostics.dir")
+	if len(dir) == 0 {
+		return nil
+	}
+	interval := configuration.SarioselfConfig.GetInt("diagnostics.alert-interval")
+	return selfservice.NewDiagnostics(dir, time.Duration(interval)*time.Minute, sendParseFailureAlert)
 }
 
 func setCallbacks(bot *miyanbor.Bot) {
@@ -40,9 +120,22 @@ func setCallbacks(bot *miyanbor.Bot) {
 	bot.AddCommandHandler("start", startCommandHandler)
 	bot.AddCommandHandler("credit", creditCommandHandler)
 	bot.AddCommandHandler("menu", menuCommandHandler)
+	bot.AddCommandHandler("self", selfCommandHandler)
 
 	bot.AddMessageHandler("اعتبار", creditCommandHandler)
 	bot.AddMessageHandler("منو", menuCommandHandler)
+	bot.AddMessageHandler("سلف", selfCommandHandler)
 
 	bot.AddCallbackHandler(foodReservePattern, foodReserveMessageHandler)
+	bot.AddCallbackHandler(foodCancelPattern, foodCancelMessageHandler)
+	bot.AddCallbackHandler(weekMenuPattern, weekMenuCallbackHandler)
+	bot.AddCallbackHandler(selfMenuPattern, selfMenuCallbackHandler)
+	bot.AddCallbackHandler(defaultSelfPattern, defaultSelfCallbackHandler)
+	// changeSelfPattern and newSelfPattern aren't handled until Samad's
+	// request of changing self is captured, see selfservice.ErrChangeSelfUnsupported
+	bot.AddCallbackHandler(foodCountPattern, foodCountCallbackHandler)
+	bot.AddCallbackHandler(startSelectionPattern, startSelectionCallbackHandler)
+	bot.AddCallbackHandler(selectFoodPattern, selectFoodCallbackHandler)
+	bot.AddCallbackHandler(confirmSelectionPattern, confirmSelectionCallbackHandler)
+	bot.AddCallbackHandler(cancelSelectionPattern, cancelSelectionCallbackHandler)
 }
@@ -0,0 +1,216 @@
+package telegram
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aryahadii/sarioself/configuration"
+	"github.com/aryahadii/sarioself/model"
+	"github.com/aryahadii/sarioself/test/fakesamad"
+	"github.com/aryahadii/sarioself/test/faketelegram"
+	"github.com/aryahadii/sarioself/ui/text"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testToken         = "123456:fake"
+	fakeSamadUsername = "9531000"
+	fakeSamadPassword = "secret"
+
+	// callTimeout is the longest tests wait for a reply of bot
+	callTimeout = 10 * time.Second
+	// settleTime is waited before each update, because the bot saves state
+	// of conversation, like the question which is asked, after sending its
+	// reply
+	settleTime = 100 * time.Millisecond
+)
+
+// testConfig uses an empty captcha samples dir, so captchas of Samad are
+// sent to users
+const testConfig = `
+bots:
+  telegram:
+    token: "%s"
+    session-timeout: 20
+    updater-timeout: 1
+    request-timeout: 10
+samad:
+  retries: 0
+  default-provider: fake
+  providers:
+    - name: fake
+      scheme: http
+      base-url: "%s"
+  captcha-solver: template
+  captcha-samples-dir: "%s"
+  manual-captcha: true
+diagnostics:
+  dir: ""
+db:
+  dialect: sqlite3
+  path: "file::memory:?cache=shared"
+`
+
+var (
+	telegramServer *faketelegram.Server
+	samadServer    *fakesamad.Server
+)
+
+// TestMain starts the bot once against a fake Telegram Bot API and a fake
+// Samad, since the bot, its database and reservation services are global
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	logrus.SetOutput(ioutil.Discard)
+
+	dir, err := ioutil.TempDir("", "sarioself-telegram")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	samadServer, err = fakesamad.New(fakeSamadUsername, fakeSamadPassword)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer samadServer.Close()
+	telegramServer = faketelegram.New(testToken)
+	defer telegramServer.Close()
+	http.DefaultTransport = telegramServer.Transport(http.DefaultTransport)
+
+	samplesDir := filepath.Join(dir, "captcha-samples")
+	configuration.SarioselfConfigPath = filepath.Join(dir, "config.yaml")
+	config := fmt.Sprintf(testConfig, testToken, samadServer.BaseURL(), samplesDir)
+	if err := os.Mkdir(samplesDir, 0755); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := ioutil.WriteFile(configuration.SarioselfConfigPath, []byte(config), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := configuration.LoadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := setupBot(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	go Bot.StartUpdater(0, configuration.SarioselfConfig.GetInt("bots.telegram.updater-timeout"))
+
+	return m.Run()
+}
+
+func sendMessage(userID int, message string) {
+	time.Sleep(settleTime)
+	telegramServer.SendMessage(userID, message)
+}
+
+func pressButton(userID, messageID int, data string) {
+	time.Sleep(settleTime)
+	telegramServer.PressButton(userID, messageID, data)
+}
+
+// expectCall waits for the next call of bot and checks that it's a call to
+// method whose text contains want
+func expectCall(t *testing.T, method, want string) *faketelegram.Call {
+	t.Helper()
+	call, err := telegramServer.NextCall(callTimeout)
+	if err != nil {
+		t.Fatalf("waiting for %v with %q, %v", method, want, err)
+	}
+	if call.Method != method || !strings.Contains(call.Params.Get("text"), want) {
+		t.Fatalf("bot called %v with %q, want %v with %q", call.Method, call.Params.Get("text"), method, want)
+	}
+	return call
+}
+
+func TestConversation(t *testing.T) {
+	const userID = 1001
+
+	sendMessage(userID, "/start")
+	expectCall(t, "sendMessage", text.MsgWelcome)
+
+	// Account of user is asked on the first command which needs it
+	sendMessage(userID, "/credit")
+	expectCall(t, "sendMessage", text.MsgEnterStudentID)
+	sendMessage(userID, fakeSamadUsername)
+	expectCall(t, "sendMessage", text.MsgEnterPassword)
+	sendMessage(userID, fakeSamadPassword)
+	expectCall(t, "sendMessage", text.MsgProfileSuccess)
+
+	// Captchas are solved by user
+	credit := model.Rials(samadServer.Credit())
+	sendMessage(userID, "/credit")
+	photo := expectCall(t, "sendPhoto", "")
+	if len(photo.File) == 0 {
+		t.Error("captcha photo is empty")
+	}
+	expectCall(t, "sendMessage", text.MsgEnterCaptcha)
+	sendMessage(userID, "00000")
+	expectCall(t, "sendPhoto", "")
+	expectCall(t, "sendMessage", text.MsgEnterCaptcha)
+	sendMessage(userID, fakesamad.CaptchaAnswer)
+	expectCall(t, "sendMessage", fmt.Sprintf(text.MsgCredit, credit.FormatTomans()))
+
+	// Reserve a food of menu
+	sendMessage(userID, "/menu")
+	menu := expectCall(t, "sendMessage", "")
+	reserveData, ok := menu.ButtonData("RES#")
+	if !ok {
+		t.Fatalf("menu has no reserve button, %v", menu.Params.Get("reply_markup"))
+	}
+	pressButton(userID, menu.MessageID, reserveData)
+	reserved := expectCall(t, "sendMessage", text.MsgReservationSuccess)
+	if samadServer.Credit() >= int(credit) {
+		t.Errorf("credit is %v after reservation, want less than %v", samadServer.Credit(), credit)
+	}
+	credit = model.Rials(samadServer.Credit())
+	if remaining := fmt.Sprintf(text.MsgRemainingCredit, credit.FormatTomans()); !strings.Contains(reserved.Params.Get("text"), remaining) {
+		t.Errorf("reservation result is %q, want remaining credit %q", reserved.Params.Get("text"), remaining)
+	}
+
+	// Client of user is reused
+	logins := samadServer.Requests("/j_security_check")
+	sendMessage(userID, "/credit")
+	expectCall(t, "sendMessage", fmt.Sprintf(text.MsgCredit, credit.FormatTomans()))
+	if samadServer.Requests("/j_security_check") != logins {
+		t.Errorf("bot logged in to Samad again")
+	}
+
+	// Select foods of menu and reserve them at once
+	sendMessage(userID, "/menu")
+	menu = expectCall(t, "sendMessage", "")
+	if _, ok := menu.ButtonData("CHS#"); ok {
+		t.Error("menu has change self button, but changing self isn't supported")
+	}
+	pressButton(userID, menu.MessageID, "BATCH")
+	edit := expectCall(t, "editMessageReplyMarkup", "")
+	if edit.MessageID != menu.MessageID {
+		t.Errorf("bot edited message %v, want menu %v", edit.MessageID, menu.MessageID)
+	}
+	selectData, ok := edit.ButtonData("TCK#")
+	if !ok {
+		t.Fatalf("selection keyboard has no food, %v", edit.Params.Get("reply_markup"))
+	}
+	pressButton(userID, menu.MessageID, selectData)
+	expectCall(t, "editMessageReplyMarkup", "")
+	pressButton(userID, menu.MessageID, "CFM")
+	expectCall(t, "editMessageReplyMarkup", "")
+	expectCall(t, "sendMessage", fmt.Sprintf(text.MsgSelectionSuccess, 1))
+	if samadServer.Credit() == int(credit) {
+		t.Error("credit didn't change after reserving selected foods")
+	}
+}
@@ -2,7 +2,9 @@ package telegram
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aryahadii/miyanbor"
@@ -24,69 +26,256 @@ func startCommandHandler(userSession *miyanbor.UserSession, matches []string, up
 }
 
 func menuCommandHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	// Menu of a week is requested like /menu 1 or /menu 1396/08/06
+	if argument := getCommandArguments(update); len(argument) > 0 {
+		week, err := parseWeek(argument)
+		if err != nil {
+			Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgInvalidWeek))
+			return
+		}
+		sendWeekMenu(userSession, "", week)
+		return
+	}
+	sendMenu(userSession, "")
+}
+
+func selfMenuCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	if matches == nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	sendMenu(userSession, matches[1])
+}
+
+// sendMenu sends foods of self which can be reserved in this week and the
+// next one, empty selfID is the default self of user
+func sendMenu(userSession *miyanbor.UserSession, selfID string) {
 	userInfo, err := getUserInfo(userSession)
 	if err != nil {
 		return
 	}
+	resume := func() {
+		sendMenu(userSession, selfID)
+	}
+	if len(selfID) == 0 {
+		selfID = userInfo.DefaultSelfID
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
 
-	// Create client
-	samadClient, err := selfservice.NewSamadAUTClient(userInfo.StudentID, userInfo.Password)
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
 	if err != nil {
-		logrus.Errorf("can't create new Samad client, %v", err)
-		msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgAnErrorOccured)
-		Bot.Send(msg)
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
 		return
 	}
 
 	// Get foods list
-	foods, err := samadClient.GetAvailableFoods()
+	foods, err := client.GetAvailableFoods(ctx, selfID)
 	if err != nil {
 		logrus.Errorf("can't GetAvailableFoods, %v", err)
-		msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgAnErrorOccured)
-		Bot.Send(msg)
+		handleSelfserviceError(userSession, err, resume)
 		return
 	}
 	sortedFoods := sortFoodsByTime(foods)
+	selves := getSelves(ctx, client)
+	selfID = getShownSelfID(selfID, sortedFoods)
 
 	// Send menu
-	keyboard := generateMenuKeyboard(sortedFoods)
-	menuMsgText := generateMenuMessage(sortedFoods)
+	keyboard := generateMenuKeyboard(sortedFoods,
+		generateSelectionRow(sortedFoods),
+		generateWeekNavigationRow(selfID, -1, 2),
+		generateSelvesRow(selves, selfID, func(selfID string) string {
+			return fmt.Sprintf(text.MenuInlineButtonData, selfID)
+		}))
+	menuMsgText := generateSelfTitle(selves, selfID) + generateMenuMessage(sortedFoods)
 	msg := telegramAPI.NewMessage(userSession.ChatID, menuMsgText)
 	msg.ReplyMarkup = keyboard
+	if sentMsg, err := Bot.Send(msg); err == nil {
+		saveMenuSelection(userSession, sentMsg.MessageID, selfID, sortedFoods, keyboard)
+	}
+}
+
+func weekMenuCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	if matches == nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	week, err := strconv.Atoi(matches[1])
+	if err != nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	sendWeekMenu(userSession, matches[2], week)
+}
+
+// sendWeekMenu sends all foods of self in week, which is relative to the
+// current week, including foods which can't be reserved anymore. Empty selfID
+// is the default self of user.
+func sendWeekMenu(userSession *miyanbor.UserSession, selfID string, week int) {
+	userInfo, err := getUserInfo(userSession)
+	if err != nil {
+		return
+	}
+	resume := func() {
+		sendWeekMenu(userSession, selfID, week)
+	}
+	if len(selfID) == 0 {
+		selfID = userInfo.DefaultSelfID
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
+
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
+	if err != nil {
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+
+	// Get foods of week
+	foods, err := client.GetWeekFoods(ctx, selfID, week)
+	if err != nil {
+		logrus.Errorf("can't GetWeekFoods, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+	sort.SliceStable(foods, func(i, j int) bool {
+		return foods[i].Date.Before(*foods[j].Date)
+	})
+	selves := getSelves(ctx, client)
+	selfID = getShownSelfID(selfID, foods)
+
+	// Send menu
+	weekStart := selfservice.WeekStart(time.Now()).AddDate(0, 0, 7*week)
+	menuMsgText := fmt.Sprintf(text.MsgWeekMenuTitle, getFormattedDate(weekStart)) +
+		generateSelfTitle(selves, selfID)
+	if len(foods) == 0 {
+		menuMsgText += text.MsgEmptyWeekMenu
+	} else {
+		menuMsgText += generateMenuMessage(foods)
+	}
+	keyboard := generateMenuKeyboard(foods,
+		generateSelectionRow(foods),
+		generateWeekNavigationRow(selfID, week-1, week+1),
+		generateSelvesRow(selves, selfID, func(selfID string) string {
+			return fmt.Sprintf(text.WeekInlineButtonData, week, selfID)
+		}))
+	msg := telegramAPI.NewMessage(userSession.ChatID, menuMsgText)
+	msg.ReplyMarkup = keyboard
+	if sentMsg, err := Bot.Send(msg); err == nil {
+		saveMenuSelection(userSession, sentMsg.MessageID, selfID, foods, keyboard)
+	}
+}
+
+func selfCommandHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	userInfo, err := getUserInfo(userSession)
+	if err != nil {
+		return
+	}
+	resume := func() {
+		selfCommandHandler(userSession, matches, update)
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
+
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
+	if err != nil {
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+
+	// Get selves
+	selves, err := client.GetSelves(ctx)
+	if err != nil {
+		logrus.Errorf("can't GetSelves, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+
+	// Send selves
+	msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgChooseDefaultSelf)
+	msg.ReplyMarkup = generateDefaultSelfKeyboard(selves, userInfo.DefaultSelfID)
 	Bot.Send(msg)
 }
 
+func defaultSelfCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	if matches == nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	userInfo, err := getUserInfo(userSession)
+	if err != nil {
+		return
+	}
+
+	err = db.GetInstance().Model(userInfo).Update("default_self_id", matches[1]).Error
+	if err != nil {
+		logrus.Errorf("can't save default self, %v", err)
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgDefaultSelfSaved))
+}
+
 func creditCommandHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
 	userInfo, err := getUserInfo(userSession)
 	if err != nil {
 		return
 	}
+	resume := func() {
+		creditCommandHandler(userSession, matches, update)
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
 
-	// Create client
-	samadClient, err := selfservice.NewSamadAUTClient(userInfo.StudentID, userInfo.Password)
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
 	if err != nil {
-		logrus.Errorf("can't create new Samad client, %v", err)
-		msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgAnErrorOccured)
-		Bot.Send(msg)
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
 		return
 	}
 
 	// Get credit
-	credit, err := samadClient.GetCredit()
+	credit, err := client.GetCredit(ctx)
 	if err != nil {
 		logrus.Errorf("can't GetCredit, %v", err)
-		msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgAnErrorOccured)
-		Bot.Send(msg)
+		handleSelfserviceError(userSession, err, resume)
 		return
 	}
 
 	// Send message
-	formattedCredit := fmt.Sprintf("%v تومان", credit/10)
+	formattedCredit := fmt.Sprintf(text.MsgCredit, credit.FormatTomans())
 	msg := telegramAPI.NewMessage(userSession.ChatID, formattedCredit)
 	Bot.Send(msg)
 }
 
 func foodReserveMessageHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	resume := func() {
+		foodReserveMessageHandler(userSession, matches, update)
+	}
+	changeFoodReservation(userSession, matches, true, resume)
+}
+
+func foodCancelMessageHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	resume := func() {
+		foodCancelMessageHandler(userSession, matches, update)
+	}
+	changeFoodReservation(userSession, matches, false, resume)
+}
+
+// changeFoodReservation reserves or cancels the food which is selected from
+// menu keyboard
+func changeFoodReservation(userSession *miyanbor.UserSession, matches []string, reserve bool, resume func()) {
 	if matches == nil {
 		sendErrorMsg(userSession.ChatID)
 		return
@@ -97,15 +286,17 @@ func foodReserveMessageHandler(userSession *miyanbor.UserSession, matches []stri
 		return
 	}
 
-	// Create client
-	samadClient, err := selfservice.NewSamadAUTClient(userInfo.StudentID, userInfo.Password)
+	ctx, cancel := newRequestContext()
+	defer cancel()
+
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
 	if err != nil {
-		logrus.Errorf("can't create new Samad client, %v", err)
-		sendErrorMsg(userSession.ChatID)
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
 		return
 	}
 
-	// Reserve
 	unixTime, err := strconv.ParseInt(matches[2], 10, 64)
 	if err != nil {
 		msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgAnErrorOccured)
@@ -113,19 +304,234 @@ func foodReserveMessageHandler(userSession *miyanbor.UserSession, matches []stri
 		return
 	}
 	mealTime := time.Unix(unixTime, 0)
-	toggled, err := samadClient.ToggleFoodReservation(&mealTime, matches[1])
-	if err != nil || !toggled {
-		if samadError, ok := err.(selfservice.SamadError); ok {
-			sendCustomErrorMsg(userSession.ChatID, samadError.What)
-		} else {
-			sendErrorMsg(userSession.ChatID)
+	// Buttons of old menus don't have self, they're of the default self
+	// of Samad
+	selfID := matches[3]
+
+	// Reserve or cancel
+	var result *selfservice.ReservationResult
+	successText := text.MsgReservationSuccess
+	if reserve {
+		// Count is chosen only for guest meals
+		count := 1
+		if len(matches) > 4 && len(matches[4]) > 0 {
+			count, _ = strconv.Atoi(matches[4])
+			successText = fmt.Sprintf(text.MsgReservationCountSuccess, count)
 		}
+		result, err = client.Reserve(ctx, selfID, &mealTime, matches[1], count)
+	} else {
+		result, err = client.Cancel(ctx, selfID, &mealTime, matches[1])
+		successText = text.MsgCancellationSuccess
+	}
+	if err != nil {
+		logrus.Errorf("can't change food reservation, %v", err)
+		handleSelfserviceError(userSession, err, resume)
 		return
 	}
+	sendReservationResult(userSession.ChatID, result, successText)
+}
 
-	// Success message
-	successMessage := telegramAPI.NewMessage(userSession.ChatID, text.MsgReservationToggleSuccess)
-	Bot.Send(successMessage)
+func changeSelfCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	if matches == nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	userInfo, err := getUserInfo(userSession)
+	if err != nil {
+		return
+	}
+	resume := func() {
+		changeSelfCallbackHandler(userSession, matches, update)
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
+
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
+	if err != nil {
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+
+	// Get selves
+	selves, err := client.GetSelves(ctx)
+	if err != nil {
+		logrus.Errorf("can't GetSelves, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+
+	// Send selves which food can be moved to
+	msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgChooseNewSelf)
+	msg.ReplyMarkup = generateNewSelfKeyboard(selves, matches[1], matches[2], matches[3])
+	Bot.Send(msg)
+}
+
+func newSelfCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	if matches == nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	userInfo, err := getUserInfo(userSession)
+	if err != nil {
+		return
+	}
+	resume := func() {
+		newSelfCallbackHandler(userSession, matches, update)
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
+
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
+	if err != nil {
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+
+	unixTime, err := strconv.ParseInt(matches[2], 10, 64)
+	if err != nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	mealTime := time.Unix(unixTime, 0)
+
+	// Change self
+	if err := client.ChangeSelf(ctx, matches[3], &mealTime, matches[1], matches[4]); err != nil {
+		logrus.Errorf("can't change self of reservation, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+	Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgSelfChangeSuccess))
+}
+
+func foodCountCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	if matches == nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	maxCount, err := strconv.Atoi(matches[4])
+	if err != nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+
+	msg := telegramAPI.NewMessage(userSession.ChatID, text.MsgChooseCount)
+	msg.ReplyMarkup = generateFoodCountKeyboard(matches[1], matches[2], matches[3], maxCount)
+	Bot.Send(msg)
+}
+
+func startSelectionCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	selectionLock.Lock()
+	defer selectionLock.Unlock()
+
+	selection := getMenuSelection(userSession, update)
+	if selection == nil {
+		Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgSelectionExpired))
+		return
+	}
+	editMenuKeyboard(userSession.ChatID, selection.MessageID, generateSelectionKeyboard(selection))
+}
+
+func selectFoodCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	if matches == nil {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	selectionLock.Lock()
+	defer selectionLock.Unlock()
+
+	selection := getMenuSelection(userSession, update)
+	if selection == nil {
+		Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgSelectionExpired))
+		return
+	}
+	index, err := strconv.Atoi(matches[1])
+	if err != nil || index >= len(selection.Foods) {
+		sendErrorMsg(userSession.ChatID)
+		return
+	}
+	if !selection.Foods[index].IsChangeable(time.Now()) {
+		Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgFoodIsLocked))
+		return
+	}
+	selection.Reserved[index] = !selection.Reserved[index]
+	editMenuKeyboard(userSession.ChatID, selection.MessageID, generateSelectionKeyboard(selection))
+}
+
+func cancelSelectionCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	selectionLock.Lock()
+	defer selectionLock.Unlock()
+
+	selection := getMenuSelection(userSession, update)
+	if selection == nil {
+		Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgSelectionExpired))
+		return
+	}
+	for i, food := range selection.Foods {
+		selection.Reserved[i] = food.Status == model.FoodStatusReserved
+	}
+	editMenuKeyboard(userSession.ChatID, selection.MessageID, selection.Keyboard)
+}
+
+func confirmSelectionCallbackHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	selectionLock.Lock()
+	selection := getMenuSelection(userSession, update)
+	if selection != nil {
+		delete(userSession.Payload, menuSelectionKey)
+	}
+	selectionLock.Unlock()
+
+	if selection == nil {
+		Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgSelectionExpired))
+		return
+	}
+	changes := selection.changes()
+	if len(changes) == 0 {
+		editMenuKeyboard(userSession.ChatID, selection.MessageID, selection.Keyboard)
+		Bot.Send(telegramAPI.NewMessage(userSession.ChatID, text.MsgNoSelectionChanges))
+		return
+	}
+	// Statuses of foods in menu aren't valid anymore
+	editMenuKeyboard(userSession.ChatID, selection.MessageID, &telegramAPI.InlineKeyboardMarkup{
+		InlineKeyboard: [][]telegramAPI.InlineKeyboardButton{},
+	})
+	applyReservationChanges(userSession, selection.SelfID, changes)
+}
+
+// applyReservationChanges reserves and cancels foods which user has selected
+func applyReservationChanges(userSession *miyanbor.UserSession, selfID string, changes []selfservice.ReservationChange) {
+	userInfo, err := getUserInfo(userSession)
+	if err != nil {
+		return
+	}
+	resume := func() {
+		applyReservationChanges(userSession, selfID, changes)
+	}
+
+	ctx, cancel := newRequestContext()
+	defer cancel()
+
+	// Get client
+	client, err := getSelfserviceClient(ctx, userInfo)
+	if err != nil {
+		logrus.Errorf("can't get selfservice client, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+
+	// Reserve and cancel
+	result, err := client.ApplyReservations(ctx, selfID, changes)
+	if err != nil {
+		logrus.Errorf("can't apply reservation changes, %v", err)
+		handleSelfserviceError(userSession, err, resume)
+		return
+	}
+	sendReservationResult(userSession.ChatID, result, fmt.Sprintf(text.MsgSelectionSuccess, len(changes)))
 }
 
 func unknownMessageHandler(userSession *miyanbor.UserSession, matches []string, update interface{}) {
@@ -133,19 +539,41 @@ func unknownMessageHandler(userSession *miyanbor.UserSession, matches []string,
 }
 
 func enterStudentIDCallback(userSession *miyanbor.UserSession, matches []string, update interface{}) {
-	userSession.Payload["student-id"] = matches[0]
+	userSession.Payload["student-id"] = getMessageText(update)
 	Bot.AskStringQuestion(text.MsgEnterPassword, userSession.UserID,
 		userSession.ChatID, enterPasswordCallback)
 }
 
 func enterPasswordCallback(userSession *miyanbor.UserSession, matches []string, update interface{}) {
-	userSession.Payload["password"] = matches[0]
+	userSession.Payload["password"] = getMessageText(update)
+
+	if reservationServices := selfservice.ProviderNames(); len(reservationServices) > 1 {
+		question := fmt.Sprintf(text.MsgEnterReservationService, strings.Join(reservationServices, "\n"))
+		Bot.AskStringQuestion(question, userSession.UserID,
+			userSession.ChatID, enterReservationServiceCallback)
+		return
+	}
+	saveUserInfo(userSession, defaultReservationService)
+}
+
+func enterReservationServiceCallback(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+	reservationService := strings.TrimSpace(getMessageText(update))
+	if !isReservationService(reservationService) {
+		question := fmt.Sprintf(text.MsgEnterReservationService, strings.Join(selfservice.ProviderNames(), "\n"))
+		Bot.AskStringQuestion(question, userSession.UserID,
+			userSession.ChatID, enterReservationServiceCallback)
+		return
+	}
+	saveUserInfo(userSession, reservationService)
+}
 
+func saveUserInfo(userSession *miyanbor.UserSession, reservationService string) {
 	// Add data to database
 	userInfo := model.User{
-		UserID:    userSession.UserID,
-		StudentID: userSession.Payload["student-id"].(string),
-		Password:  userSession.Payload["password"].(string),
+		UserID:             userSession.UserID,
+		ReservationService: reservationService,
+		StudentID:          userSession.Payload["student-id"].(string),
+		Password:           userSession.Payload["password"].(string),
 	}
 	db.GetInstance().Create(&userInfo)
 
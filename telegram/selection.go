@@ -0,0 +1,130 @@
+package telegram
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aryahadii/miyanbor"
+	"github.com/aryahadii/sarioself/model"
+	"github.com/aryahadii/sarioself/selfservice"
+	"github.com/aryahadii/sarioself/ui/text"
+	telegramAPI "gopkg.in/telegram-bot-api.v4"
+)
+
+// menuSelectionKey is key of the last menu of user in payload of session
+const menuSelectionKey = "menu-selection"
+
+// selectionLock guards selections in payload of sessions, updates of a user
+// are handled concurrently
+var selectionLock sync.Mutex
+
+// menuSelection is the last menu which is sent to user. User can select
+// several foods of it and reserve or cancel them together.
+type menuSelection struct {
+	MessageID int
+	SelfID    string
+	Foods     []*model.Food
+	// Reserved is the requested state of each of Foods
+	Reserved []bool
+	// Keyboard is keyboard of menu, it's shown again if selection is
+	// cancelled
+	Keyboard *telegramAPI.InlineKeyboardMarkup
+}
+
+// saveMenuSelection keeps foods of menu which is sent in message, so they can
+// be selected later
+func saveMenuSelection(userSession *miyanbor.UserSession, messageID int, selfID string,
+	foods []*model.Food, keyboard *telegramAPI.InlineKeyboardMarkup) {
+	selection := &menuSelection{
+		MessageID: messageID,
+		SelfID:    selfID,
+		Foods:     foods,
+		Reserved:  make([]bool, len(foods)),
+		Keyboard:  keyboard,
+	}
+	for i, food := range foods {
+		selection.Reserved[i] = food.Status == model.FoodStatusReserved
+	}
+
+	selectionLock.Lock()
+	defer selectionLock.Unlock()
+	userSession.Payload[menuSelectionKey] = selection
+}
+
+// getMenuSelection returns selection of the menu whose button is pressed in
+// update, it returns nil if the menu isn't the last menu of user. selectionLock
+// should be held by caller.
+func getMenuSelection(userSession *miyanbor.UserSession, update interface{}) *menuSelection {
+	telegramUpdate, ok := update.(*telegramAPI.Update)
+	if !ok || telegramUpdate.CallbackQuery == nil || telegramUpdate.CallbackQuery.Message == nil {
+		return nil
+	}
+	selection, ok := userSession.Payload[menuSelectionKey].(*menuSelection)
+	if !ok || selection.MessageID != telegramUpdate.CallbackQuery.Message.MessageID {
+		return nil
+	}
+	return selection
+}
+
+// changes returns reservation changes which user has selected
+func (m *menuSelection) changes() []selfservice.ReservationChange {
+	var changes []selfservice.ReservationChange
+	for i, food := range m.Foods {
+		if m.Reserved[i] == (food.Status == model.FoodStatusReserved) {
+			continue
+		}
+		change := selfservice.ReservationChange{
+			Date:   *food.Date,
+			FoodID: food.ID,
+		}
+		if m.Reserved[i] {
+			change.Count = 1
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// generateSelectionRow creates button which starts selecting foods of menu
+func generateSelectionRow(foods []*model.Food) []telegramAPI.InlineKeyboardButton {
+	now := time.Now()
+	for _, food := range foods {
+		if food.Status != model.FoodStatusUnavailable && food.IsChangeable(now) {
+			return telegramAPI.NewInlineKeyboardRow(
+				telegramAPI.NewInlineKeyboardButtonData(text.MsgKeyboardStartSelection, text.StartSelectionInlineButtonData))
+		}
+	}
+	return nil
+}
+
+// generateSelectionKeyboard creates buttons which select foods of menu and
+// confirm or cancel selection
+func generateSelectionKeyboard(selection *menuSelection) *telegramAPI.InlineKeyboardMarkup {
+	rows := [][]telegramAPI.InlineKeyboardButton{}
+	now := time.Now()
+	for i, food := range selection.Foods {
+		if food.Status == model.FoodStatusUnavailable || !food.IsChangeable(now) {
+			continue
+		}
+		caption := fmt.Sprintf(text.MsgKeyboardNotSelectedFoodItem, getFormattedWeekday(*food.Date), food.Name)
+		if selection.Reserved[i] {
+			caption = fmt.Sprintf(text.MsgKeyboardSelectedFoodItem, getFormattedWeekday(*food.Date), food.Name)
+		}
+		data := fmt.Sprintf(text.SelectFoodInlineButtonData, i)
+		rows = append(rows, telegramAPI.NewInlineKeyboardRow(telegramAPI.NewInlineKeyboardButtonData(caption, data)))
+	}
+
+	btnConfirm := telegramAPI.NewInlineKeyboardButtonData(
+		fmt.Sprintf(text.MsgKeyboardConfirmSelection, len(selection.changes())), text.ConfirmSelectionInlineButtonData)
+	btnCancel := telegramAPI.NewInlineKeyboardButtonData(text.MsgKeyboardCancelSelection, text.CancelSelectionInlineButtonData)
+	rows = append(rows, telegramAPI.NewInlineKeyboardRow(btnConfirm, btnCancel))
+
+	markup := telegramAPI.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// editMenuKeyboard replaces keyboard of menu message
+func editMenuKeyboard(chatID int64, messageID int, keyboard *telegramAPI.InlineKeyboardMarkup) {
+	Bot.Send(telegramAPI.NewEditMessageReplyMarkup(chatID, messageID, *keyboard))
+}
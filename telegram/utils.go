@@ -1,15 +1,20 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aryahadii/miyanbor"
 	"github.com/aryahadii/sarioself/db"
 	"github.com/aryahadii/sarioself/model"
+	"github.com/aryahadii/sarioself/selfservice"
 	"github.com/aryahadii/sarioself/ui/text"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/yaa110/go-persian-calendar/ptime"
 	telegramAPI "gopkg.in/telegram-bot-api.v4"
 )
@@ -25,6 +30,31 @@ func getUserInfo(userSession *miyanbor.UserSession) (*model.User, error) {
 	return &userInfo, nil
 }
 
+// newRequestContext returns context of requests which are sent to
+// reservation service while handling an update
+func newRequestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
+
+// getSelfserviceClient returns logged-in client of user on the reservation
+// service which user has chosen
+func getSelfserviceClient(ctx context.Context, userInfo *model.User) (selfservice.Client, error) {
+	reservationService := userInfo.ReservationService
+	if len(reservationService) == 0 {
+		reservationService = defaultReservationService
+	}
+	return selfservice.NewClient(ctx, reservationService, userInfo.StudentID, userInfo.Password)
+}
+
+func isReservationService(name string) bool {
+	for _, reservationService := range selfservice.ProviderNames() {
+		if reservationService == name {
+			return true
+		}
+	}
+	return false
+}
+
 var (
 	weekdays = map[int]string{
 		0: "شنبه",
@@ -42,6 +72,11 @@ var (
 	}
 )
 
+func getFormattedDate(time time.Time) string {
+	jalaliDate := ptime.New(time)
+	return fmt.Sprintf("%d/%02d/%02d", jalaliDate.Year(), int(jalaliDate.Month()), jalaliDate.Day())
+}
+
 func getFormattedDayWeekday(time time.Time) string {
 	jalaliDate := ptime.New(time)
 	return fmt.Sprintf("%s %dام", weekdays[int(jalaliDate.Weekday())], jalaliDate.Day())
@@ -56,29 +91,154 @@ func generateMainKeyboard() *telegramAPI.ReplyKeyboardMarkup {
 	rows := [][]telegramAPI.KeyboardButton{}
 	btnMenu := telegramAPI.NewKeyboardButton(text.MsgMainKeyboardMenu)
 	btnCredit := telegramAPI.NewKeyboardButton(text.MsgMainKeyboardCredit)
-	rows = append(rows, telegramAPI.NewKeyboardButtonRow(btnMenu, btnCredit))
+	btnSelf := telegramAPI.NewKeyboardButton(text.MsgMainKeyboardSelf)
+	rows = append(rows, telegramAPI.NewKeyboardButtonRow(btnMenu, btnCredit, btnSelf))
 
 	markup := telegramAPI.NewReplyKeyboard(rows...)
 	return &markup
 }
 
-func generateMenuKeyboard(foods []*model.Food) *telegramAPI.InlineKeyboardMarkup {
+// generateMenuKeyboard creates buttons which reserve or cancel foods followed
+// by extraRows, empty rows are skipped
+func generateMenuKeyboard(foods []*model.Food, extraRows ...[]telegramAPI.InlineKeyboardButton) *telegramAPI.InlineKeyboardMarkup {
 	rows := [][]telegramAPI.InlineKeyboardButton{}
+	now := time.Now()
 	for _, food := range foods {
+		if food.Status == model.FoodStatusUnavailable || !food.IsChangeable(now) {
+			continue
+		}
 		formattedTime := getFormattedWeekday(*food.Date)
+		unixTime := strconv.FormatInt(food.Date.Unix(), 10)
 		caption := fmt.Sprintf(text.MsgKeyboardFoodItem, formattedTime, food.Name)
-		data := fmt.Sprintf(text.FoodInlineButtonData, food.ID, strconv.FormatInt(food.Date.Unix(), 10))
+		data := fmt.Sprintf(text.FoodInlineButtonData, food.ID, unixTime, food.SelfID)
+		if food.Status == model.FoodStatusReserved {
+			caption = fmt.Sprintf(text.MsgKeyboardCancelFoodItem, formattedTime, food.Name)
+			data = fmt.Sprintf(text.FoodCancelInlineButtonData, food.ID, unixTime, food.SelfID)
+		}
 		button := telegramAPI.NewInlineKeyboardButtonData(caption, data)
 
 		row := telegramAPI.NewInlineKeyboardRow(button)
+		if food.MaxCount > 1 {
+			data := fmt.Sprintf(text.FoodCountInlineButtonData, food.ID, unixTime, food.SelfID, food.MaxCount)
+			row = append(row, telegramAPI.NewInlineKeyboardButtonData(text.MsgKeyboardFoodCount, data))
+		}
 		rows = append(rows, row)
 	}
+	for _, row := range extraRows {
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	markup := telegramAPI.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// generateWeekNavigationRow creates buttons which show menu of self in
+// previousWeek and nextWeek
+func generateWeekNavigationRow(selfID string, previousWeek, nextWeek int) []telegramAPI.InlineKeyboardButton {
+	// Buttons are right to left like the text
+	btnNextWeek := telegramAPI.NewInlineKeyboardButtonData(text.MsgKeyboardNextWeek,
+		fmt.Sprintf(text.WeekInlineButtonData, nextWeek, selfID))
+	btnPreviousWeek := telegramAPI.NewInlineKeyboardButtonData(text.MsgKeyboardPreviousWeek,
+		fmt.Sprintf(text.WeekInlineButtonData, previousWeek, selfID))
+	return telegramAPI.NewInlineKeyboardRow(btnNextWeek, btnPreviousWeek)
+}
+
+// generateSelvesRow creates buttons which show menu of selves other than
+// shownSelfID, data returns callback data of a self
+func generateSelvesRow(selves []selfservice.Self, shownSelfID string, data func(selfID string) string) []telegramAPI.InlineKeyboardButton {
+	var row []telegramAPI.InlineKeyboardButton
+	for _, self := range selves {
+		if self.ID != shownSelfID {
+			caption := fmt.Sprintf(text.MsgKeyboardSelf, self.Name)
+			row = append(row, telegramAPI.NewInlineKeyboardButtonData(caption, data(self.ID)))
+		}
+	}
+	return row
+}
+
+// foodCountKeyboardWidth is number of count buttons in each row
+const foodCountKeyboardWidth = 5
+
+// generateFoodCountKeyboard creates buttons which reserve 1 to maxCount
+// portions of food, data of food is the same as its count button
+func generateFoodCountKeyboard(foodID, unixTime, selfID string, maxCount int) *telegramAPI.InlineKeyboardMarkup {
+	rows := [][]telegramAPI.InlineKeyboardButton{}
+	var row []telegramAPI.InlineKeyboardButton
+	for count := 1; count <= maxCount; count++ {
+		caption := fmt.Sprintf(text.MsgKeyboardPortions, count)
+		data := fmt.Sprintf(text.FoodReserveCountInlineButtonData, foodID, unixTime, selfID, count)
+		row = append(row, telegramAPI.NewInlineKeyboardButtonData(caption, data))
+		if len(row) == foodCountKeyboardWidth || count == maxCount {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	markup := telegramAPI.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+// generateNewSelfKeyboard creates buttons which move reservation of food from
+// its self to one of other selves, data of food is the same as its change
+// self button
+func generateNewSelfKeyboard(selves []selfservice.Self, foodID, unixTime, selfID string) *telegramAPI.InlineKeyboardMarkup {
+	rows := [][]telegramAPI.InlineKeyboardButton{}
+	for _, self := range selves {
+		if self.ID != selfID {
+			data := fmt.Sprintf(text.NewSelfInlineButtonData, foodID, unixTime, selfID, self.ID)
+			rows = append(rows, telegramAPI.NewInlineKeyboardRow(telegramAPI.NewInlineKeyboardButtonData(self.Name, data)))
+		}
+	}
+	markup := telegramAPI.NewInlineKeyboardMarkup(rows...)
+	return &markup
+}
+
+func generateDefaultSelfKeyboard(selves []selfservice.Self, defaultSelfID string) *telegramAPI.InlineKeyboardMarkup {
+	rows := [][]telegramAPI.InlineKeyboardButton{}
+	for _, self := range selves {
+		caption := self.Name
+		if self.ID == defaultSelfID {
+			caption = fmt.Sprintf(text.MsgKeyboardDefaultSelf, self.Name)
+		}
+		data := fmt.Sprintf(text.DefaultSelfInlineButtonData, self.ID)
+		rows = append(rows, telegramAPI.NewInlineKeyboardRow(telegramAPI.NewInlineKeyboardButtonData(caption, data)))
+	}
 	markup := telegramAPI.NewInlineKeyboardMarkup(rows...)
 	return &markup
 }
 
+// getSelves returns selves of user, menus are sent without selves if they
+// can't be loaded
+func getSelves(ctx context.Context, client selfservice.Client) []selfservice.Self {
+	selves, err := client.GetSelves(ctx)
+	if err != nil {
+		logrus.Errorf("can't GetSelves, %v", err)
+	}
+	return selves
+}
+
+// getShownSelfID returns self of menu, which is the self that Samad has
+// chosen if selfID is empty
+func getShownSelfID(selfID string, foods []*model.Food) string {
+	if len(selfID) == 0 && len(foods) > 0 {
+		return foods[0].SelfID
+	}
+	return selfID
+}
+
+// generateSelfTitle returns name of self for top of menu
+func generateSelfTitle(selves []selfservice.Self, selfID string) string {
+	for _, self := range selves {
+		if self.ID == selfID {
+			return fmt.Sprintf(text.MsgMenuSelfTitle, self.Name)
+		}
+	}
+	return ""
+}
+
 func generateMenuMessage(foods []*model.Food) string {
 	menuMsgText := ""
+	now := time.Now()
 	for _, food := range foods {
 		formattedTime := getFormattedDayWeekday(*food.Date)
 
@@ -88,21 +248,59 @@ func generateMenuMessage(foods []*model.Food) string {
 		}
 
 		mealTimeString := mealTime[int(food.MealTime)]
+		foodName := food.Name
+		if food.Count > 1 {
+			foodName = fmt.Sprintf(text.MsgFoodCount, food.Name, food.Count)
+		}
 
 		if food.Status == model.FoodStatusUnavailable {
 			menuMsgText += fmt.Sprintf(text.MsgNotSelectableFoodMenuItem,
-				mealTimeString, formattedTime, food.Name, sideDish, strconv.Itoa(food.PriceTooman))
+				mealTimeString, formattedTime, foodName, sideDish, food.Price.FormatTomans(), "")
 		} else if food.Status == model.FoodStatusReserved {
 			menuMsgText += fmt.Sprintf(text.MsgSelectedFoodMenuItem,
-				mealTimeString, formattedTime, food.Name, sideDish, strconv.Itoa(food.PriceTooman))
+				mealTimeString, formattedTime, foodName, sideDish, food.Price.FormatTomans(),
+				generateDeadlineNote(food, now))
 		} else {
 			menuMsgText += fmt.Sprintf(text.MsgNotSelectedFoodMenuItem,
-				mealTimeString, formattedTime, food.Name, sideDish, strconv.Itoa(food.PriceTooman))
+				mealTimeString, formattedTime, foodName, sideDish, food.Price.FormatTomans(),
+				generateDeadlineNote(food, now))
 		}
 	}
 	return menuMsgText
 }
 
+// generateDeadlineNote tells how long reservation of food can be changed,
+// it's empty if deadline of food is unknown
+func generateDeadlineNote(food *model.Food, now time.Time) string {
+	if food.ChangeableUntil == nil {
+		return ""
+	}
+	if !food.IsChangeable(now) {
+		return text.MsgFoodLocked
+	}
+	return fmt.Sprintf(text.MsgFoodChangeableFor, getFormattedDuration(food.ChangeableUntil.Sub(now)))
+}
+
+// getFormattedDuration formats duration in days, hours and minutes, only the
+// two largest non-zero parts are shown
+func getFormattedDuration(duration time.Duration) string {
+	minutes := int(duration / time.Minute)
+	parts := []string{}
+	if days := minutes / (24 * 60); days > 0 {
+		parts = append(parts, fmt.Sprintf(text.MsgDays, days))
+	}
+	if hours := minutes / 60 % 24; hours > 0 {
+		parts = append(parts, fmt.Sprintf(text.MsgHours, hours))
+	}
+	if minutes%60 > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf(text.MsgMinutes, minutes%60))
+	}
+	if len(parts) > 2 {
+		parts = parts[:2]
+	}
+	return strings.Join(parts, text.MsgDurationSeparator)
+}
+
 func sortFoodsByTime(foods map[time.Time][]*model.Food) []*model.Food {
 	keys := []time.Time{}
 	for key := range foods {
@@ -124,6 +322,157 @@ func sendErrorMsg(chatID int64) {
 	Bot.Send(msg)
 }
 
+// handleSelfserviceError tells user what went wrong with reservation service.
+// If user should solve captcha of login, it asks for captcha and calls resume
+// after login.
+func handleSelfserviceError(userSession *miyanbor.UserSession, err error, resume func()) {
+	captchaRequired, ok := errors.Cause(err).(*selfservice.CaptchaRequiredError)
+	if !ok {
+		sendSelfserviceErrorMsg(userSession.ChatID, err)
+		return
+	}
+
+	captchaPhoto := telegramAPI.NewPhotoUpload(userSession.ChatID, telegramAPI.FileBytes{
+		Name:  "captcha.jpg",
+		Bytes: captchaRequired.Challenge.CaptchaImage,
+	})
+	Bot.Send(captchaPhoto)
+	Bot.AskStringQuestion(text.MsgEnterCaptcha, userSession.UserID, userSession.ChatID,
+		func(userSession *miyanbor.UserSession, matches []string, update interface{}) {
+			ctx, cancel := newRequestContext()
+			defer cancel()
+
+			err := captchaRequired.Challenge.Solve(ctx, getMessageText(update))
+			if err != nil {
+				logrus.Errorf("can't login using captcha of user, %v", err)
+				handleSelfserviceError(userSession, err, resume)
+				return
+			}
+			resume()
+		})
+}
+
+// getMessageText returns text of update's message
+func getMessageText(update interface{}) string {
+	if telegramUpdate, ok := update.(*telegramAPI.Update); ok && telegramUpdate.Message != nil {
+		return telegramUpdate.Message.Text
+	}
+	return ""
+}
+
+// getCommandArguments returns text of command after its name
+func getCommandArguments(update interface{}) string {
+	if telegramUpdate, ok := update.(*telegramAPI.Update); ok && telegramUpdate.Message != nil {
+		return strings.TrimSpace(telegramUpdate.Message.CommandArguments())
+	}
+	return ""
+}
+
+var localDigits = strings.NewReplacer(
+	"۰", "0", "۱", "1", "۲", "2", "۳", "3", "۴", "4", "۵", "5", "۶", "6", "۷", "7", "۸", "8", "۹", "9",
+	"٠", "0", "١", "1", "٢", "2", "٣", "3", "٤", "4", "٥", "5", "٦", "6", "٧", "7", "٨", "8", "٩", "9",
+)
+
+// parseWeek parses week relative to the current one, like -1, or a Jalali
+// date which is in the week, like 1396/08/06
+func parseWeek(week string) (int, error) {
+	week = localDigits.Replace(strings.TrimSpace(week))
+	if relativeWeek, err := strconv.Atoi(week); err == nil {
+		return relativeWeek, nil
+	}
+	date, err := selfservice.ParseJalaliDate(week)
+	if err != nil {
+		return 0, err
+	}
+	return selfservice.WeekOf(date), nil
+}
+
+// sendSelfserviceErrorMsg tells user what went wrong with reservation service
+func sendSelfserviceErrorMsg(chatID int64, err error) {
+	switch cause := errors.Cause(err).(type) {
+	case selfservice.SamadError:
+		sendCustomErrorMsg(chatID, cause.What)
+	case selfservice.NetworkError:
+		if cause.Timeout() {
+			sendCustomErrorMsg(chatID, text.MsgSelfserviceTimeout)
+		} else {
+			sendCustomErrorMsg(chatID, text.MsgSelfserviceUnreachable)
+		}
+	case selfservice.StatusError:
+		sendCustomErrorMsg(chatID, text.MsgSelfserviceUnavailable)
+	case selfservice.ParseError:
+		sendCustomErrorMsg(chatID, text.MsgSelfserviceChanged)
+	default:
+		switch cause {
+		case selfservice.ErrInvalidCredentials:
+			sendCustomErrorMsg(chatID, text.MsgInvalidCredentials)
+		case selfservice.ErrWrongCaptcha:
+			sendCustomErrorMsg(chatID, text.MsgWrongCaptcha)
+		case selfservice.ErrAccountLocked:
+			sendCustomErrorMsg(chatID, text.MsgAccountLocked)
+		case selfservice.ErrSessionExpired:
+			sendCustomErrorMsg(chatID, text.MsgSessionExpired)
+		case selfservice.ErrFoodNotFound:
+			sendCustomErrorMsg(chatID, text.MsgFoodNotFound)
+		case selfservice.ErrFoodUnavailable:
+			sendCustomErrorMsg(chatID, text.MsgFoodUnavailable)
+		case selfservice.ErrWeekOutOfRange:
+			sendCustomErrorMsg(chatID, text.MsgWeekOutOfRange)
+		case selfservice.ErrSelfNotFound:
+			sendCustomErrorMsg(chatID, text.MsgSelfNotFound)
+		case selfservice.ErrFoodNotReserved:
+			sendCustomErrorMsg(chatID, text.MsgFoodNotReserved)
+		case selfservice.ErrSelfChangeFailed:
+			sendCustomErrorMsg(chatID, text.MsgSelfChangeFailed)
+		case selfservice.ErrChangeSelfUnsupported:
+			sendCustomErrorMsg(chatID, text.MsgChangeSelfUnsupported)
+		case selfservice.ErrCountNotAllowed:
+			sendCustomErrorMsg(chatID, text.MsgCountNotAllowed)
+		default:
+			sendErrorMsg(chatID)
+		}
+	}
+}
+
+// sendReservationResult tells user whether reservation changes are applied
+// and how much credit is left
+func sendReservationResult(chatID int64, result *selfservice.ReservationResult, successText string) {
+	var msgText string
+	switch result.Status {
+	case selfservice.ReservationApplied:
+		msgText = successText
+	case selfservice.ReservationPartiallyApplied:
+		msgText = fmt.Sprintf(text.MsgReservationPartiallyApplied,
+			len(result.Applied), len(result.Applied)+len(result.Failed))
+	default:
+		msgText = text.MsgReservationRejected
+	}
+	if result.Status != selfservice.ReservationApplied && len(result.Reason) > 0 {
+		msgText += fmt.Sprintf(text.MsgReservationRejectionReason, result.Reason)
+	}
+	msgText += fmt.Sprintf(text.MsgRemainingCredit, result.Credit.FormatTomans())
+	Bot.Send(telegramAPI.NewMessage(chatID, msgText))
+}
+
+// sendParseFailureAlert tells admins that a Samad page couldn't be parsed
+func sendParseFailureAlert(failure *selfservice.ParseFailure) {
+	var fields []string
+	for _, err := range failure.Errors {
+		fields = append(fields, fmt.Sprintf(text.MsgParseFailureField, err.Field, err.Selector))
+	}
+	alert := fmt.Sprintf(text.MsgParseFailureAlert, failure.Provider, strings.Join(fields, "\n"))
+	if len(failure.Path) > 0 {
+		alert += fmt.Sprintf(text.MsgParseFailureSnapshot, failure.Path)
+	}
+	if failure.Suppressed > 0 {
+		alert += fmt.Sprintf(text.MsgParseFailureSuppressed, failure.Suppressed)
+	}
+
+	for _, admin := range admins {
+		Bot.Send(telegramAPI.NewMessage(admin, alert))
+	}
+}
+
 func sendCustomErrorMsg(chatID int64, errorMessage string) {
 	msg := telegramAPI.NewMessage(chatID, errorMessage)
 	Bot.Send(msg)
@@ -0,0 +1,618 @@
+// Package fakesamad is a fake Samad which serves pages of test/samad, so
+// Samad clients can be tested without network
+package fakesamad
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/yaa110/go-persian-calendar/ptime"
+)
+
+const (
+	// CaptchaAnswer is text of captchas which Server serves
+	CaptchaAnswer = "12345"
+
+	sessionCookie = "JSESSIONID"
+
+	loginPagePath    = "/loginpage.rose"
+	loginBackendPath = "/j_security_check"
+	captchaPath      = "/captcha.jpg"
+	reservationPath  = "/nurture/user/multi/reserve/reserve.rose"
+
+	// Messages of Samad which clients map to errors
+	msgWrongCaptcha       = "کد امنیتی وارد شده صحیح نیست"
+	msgInvalidCredentials = "نام کاربری یا رمز عبور اشتباه است"
+	msgNotEnoughCredit    = "اعتبار شما برای این رزرو کافی نیست"
+	msgOneFoodPerMeal     = "در هر وعده فقط یک غذا قابل رزرو است"
+
+	loginPage = `<html>
+<head><script>var _csrf_token_headers = { 'X-CSRF-TOKEN' : '%s' };</script></head>
+<body>
+<div class="error">%s</div>
+<form action="j_security_check" method="post">
+<input type="hidden" name="_csrf" value="%s">
+<input type="text" name="username"><input type="password" name="password">
+<img src="captcha.jpg"><input type="text" name="captcha_input">
+</form>
+</body>
+</html>`
+
+	// homePage is the page which Samad shows after login
+	homePage = `<html>
+<head><script>var _csrf_token_headers = { 'X-CSRF-TOKEN' : '%s' };</script></head>
+<body><a href="nurture/user/multi/reserve/reserve.rose">رزرو غذا</a></body>
+</html>`
+)
+
+var (
+	csrfRegex        = regexp.MustCompile(`'X-CSRF-TOKEN'\s*:\s*'([^']*)'`)
+	reserveNameRegex = regexp.MustCompile(`^userWeekReserves\[(\d+)\]\.selected$`)
+)
+
+// Server is a fake Samad of a single user. Week 0 and the weeks after it are
+// served from reserve_available.html and weeks before it from
+// reserve_notavailable.html, with their dates moved to the week they're
+// served for. Changing self of reservations isn't implemented since form of
+// Samad's change self dialog hasn't been captured, its submissions are
+// ignored like other unknown ones.
+type Server struct {
+	*httptest.Server
+
+	Username string
+	Password string
+	// MaxCount is the most portions of a food which can be reserved
+	MaxCount int
+	// MinCredit is the least credit in Rials which reservations can leave
+	MinCredit int
+
+	lock      sync.Mutex
+	available *fixture
+	past      *fixture
+	sessions  map[string]*session
+	credit    int
+	reserves  map[weekKey][]reserveState
+	lastID    int
+	captcha   []byte
+	// Requests is number of requests which are served, by their paths
+	requests map[string]int
+}
+
+type session struct {
+	csrf     string
+	loggedIn bool
+	selfID   string
+	week     int
+}
+
+type weekKey struct {
+	selfID string
+	week   int
+}
+
+type reserveState struct {
+	id    string
+	count int
+}
+
+// fixture is a reservation page of test/samad
+type fixture struct {
+	html      string
+	csrf      string
+	weekStart time.Time
+	selves    []string
+	// prices and locked of foods by their index
+	prices []int
+	locked []bool
+	// meals of foods by their index, foods of a meal are alternatives
+	// and only one of them can be reserved
+	meals []string
+}
+
+// New starts a fake Samad whose user is username with password
+func New(username, password string) (*Server, error) {
+	_, source, _, _ := runtime.Caller(0)
+	fixturesDir := filepath.Join(filepath.Dir(source), "..", "samad")
+	available, err := loadFixture(filepath.Join(fixturesDir, "reserve_available.html"))
+	if err != nil {
+		return nil, err
+	}
+	past, err := loadFixture(filepath.Join(fixturesDir, "reserve_notavailable.html"))
+	if err != nil {
+		return nil, err
+	}
+	captcha, err := newCaptchaImage()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Username:  username,
+		Password:  password,
+		MaxCount:  1,
+		MinCredit: math.MinInt32,
+		available: available,
+		past:      past,
+		sessions:  make(map[string]*session),
+		credit:    -24549,
+		reserves:  make(map[weekKey][]reserveState),
+		captcha:   captcha,
+		requests:  make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(loginPagePath, s.handleLoginPage)
+	mux.HandleFunc(captchaPath, s.handleCaptcha)
+	mux.HandleFunc(loginBackendPath, s.handleLogin)
+	mux.HandleFunc(reservationPath, s.handleReservation)
+	s.Server = httptest.NewServer(mux)
+	return s, nil
+}
+
+// BaseURL returns address of server without its scheme, like base-url of
+// Samad providers
+func (s *Server) BaseURL() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// Credit returns credit of user in Rials
+func (s *Server) Credit() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.credit
+}
+
+// SetCredit changes credit of user, credit is in Rials
+func (s *Server) SetCredit(credit int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.credit = credit
+}
+
+// Reserved returns number of reserved portions of food index in week of
+// self, week is relative to the current week
+func (s *Server) Reserved(selfID string, week, index int) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	reserves := s.weekReserves(selfID, week)
+	if reserves == nil || index >= len(reserves) {
+		return 0
+	}
+	return reserves[index].count
+}
+
+// Requests returns number of requests which are sent to path
+func (s *Server) Requests(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[path]
+}
+
+// ExpireSessions logs out all sessions, like Samad does after a while
+func (s *Server) ExpireSessions() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sessions = make(map[string]*session)
+}
+
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests[r.URL.Path]++
+
+	userSession := s.newSession(w)
+	fmt.Fprintf(w, loginPage, userSession.csrf, "", userSession.csrf)
+}
+
+func (s *Server) handleCaptcha(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests[r.URL.Path]++
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write(s.captcha)
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests[r.URL.Path]++
+
+	userSession := s.session(r)
+	if r.Method != http.MethodPost || userSession == nil || r.FormValue("_csrf") != userSession.csrf {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	message := ""
+	if r.FormValue("captcha_input") != CaptchaAnswer {
+		message = msgWrongCaptcha
+	} else if r.FormValue("username") != s.Username || r.FormValue("password") != s.Password {
+		message = msgInvalidCredentials
+	}
+	if len(message) > 0 {
+		fmt.Fprintf(w, loginPage, userSession.csrf, message, userSession.csrf)
+		return
+	}
+
+	userSession.loggedIn = true
+	fmt.Fprintf(w, homePage, userSession.csrf)
+}
+
+func (s *Server) handleReservation(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests[r.URL.Path]++
+
+	userSession := s.session(r)
+	if userSession == nil || !userSession.loggedIn {
+		http.Redirect(w, r, loginPagePath, http.StatusFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		userSession.selfID = s.available.selves[0]
+		userSession.week = 0
+		s.writeReservePage(w, userSession, "")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("X-Csrf-Token") != userSession.csrf && r.PostForm.Get("_csrf") != userSession.csrf {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+
+	if weekStart, err := parseMillis(r.PostForm.Get("weekStartDateTime")); err == nil {
+		userSession.week = weekOf(weekStart)
+	}
+	if selfID := r.PostForm.Get("selectedSelfDefId"); len(selfID) > 0 && hasSelf(s.available.selves, selfID) {
+		userSession.selfID = selfID
+	}
+
+	message := ""
+	switch {
+	case len(r.PostForm.Get("method:showNextWeek")) > 0:
+		userSession.week++
+	case len(r.PostForm.Get("method:showPreviousWeek")) > 0:
+		userSession.week--
+	case len(r.PostForm.Get("method:doReserve")) > 0:
+		message = s.reserve(userSession, r)
+	}
+	s.writeReservePage(w, userSession, message)
+}
+
+// reserve applies reservations of form to week of session, it returns
+// message of Samad if they're rejected
+func (s *Server) reserve(userSession *session, r *http.Request) string {
+	reserves := s.weekReserves(userSession.selfID, userSession.week)
+	if reserves == nil {
+		// Past weeks can't be changed, Samad ignores them
+		return ""
+	}
+
+	newReserves := append([]reserveState(nil), reserves...)
+	credit := s.credit
+	for index := range newReserves {
+		if s.available.locked[index] {
+			continue
+		}
+		prefix := fmt.Sprintf("userWeekReserves[%d]", index)
+		count := 0
+		if r.PostForm.Get(prefix+".selected") == "true" {
+			count = 1
+			if selectedCount, err := strconv.Atoi(r.PostForm.Get(prefix + ".selectedCount")); err == nil {
+				count = selectedCount
+			}
+		}
+		if count < 0 || count > s.MaxCount {
+			continue
+		}
+
+		credit -= s.available.prices[index] * (count - newReserves[index].count)
+		if count == 0 {
+			newReserves[index] = reserveState{}
+		} else if newReserves[index].count == 0 {
+			s.lastID++
+			newReserves[index] = reserveState{id: strconv.Itoa(9000000 + s.lastID), count: count}
+		} else {
+			newReserves[index].count = count
+		}
+	}
+	reservedMeals := make(map[string]bool)
+	for index, reserve := range newReserves {
+		if reserve.count == 0 {
+			continue
+		}
+		meal := s.available.meals[index]
+		if reservedMeals[meal] {
+			return msgOneFoodPerMeal
+		}
+		reservedMeals[meal] = true
+	}
+	if credit < s.MinCredit {
+		return msgNotEnoughCredit
+	}
+
+	s.credit = credit
+	s.reserves[weekKey{userSession.selfID, userSession.week}] = newReserves
+	return ""
+}
+
+// weekReserves returns state of foods of week of self, it's nil for past
+// weeks which can't be changed
+func (s *Server) weekReserves(selfID string, week int) []reserveState {
+	if week < 0 {
+		return nil
+	}
+	key := weekKey{selfID, week}
+	if _, ok := s.reserves[key]; !ok {
+		s.reserves[key] = make([]reserveState, len(s.available.prices))
+	}
+	return s.reserves[key]
+}
+
+// writeReservePage writes page of week of session, message is shown as the
+// error of Samad
+func (s *Server) writeReservePage(w http.ResponseWriter, userSession *session, message string) {
+	page, err := s.renderReservePage(userSession, message)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	fmt.Fprint(w, page)
+}
+
+func (s *Server) renderReservePage(userSession *session, message string) (string, error) {
+	pageFixture := s.available
+	if userSession.week < 0 {
+		pageFixture = s.past
+	}
+	html := strings.Replace(pageFixture.html, pageFixture.csrf, userSession.csrf, -1)
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return "", err
+	}
+
+	// Dates
+	weekStart := weekStartOf(time.Now()).AddDate(0, 0, 7*userSession.week)
+	// Days are rounded since some of them are an hour shorter or longer
+	shift := int(math.Floor(weekStart.Sub(pageFixture.weekStart).Hours()/24 + 0.5))
+	document.Find("input[name=weekStartDateTime]").SetAttr("value", formatMillis(weekStart))
+	document.Find(`input[name$=".programDateTime"]`).Each(func(i int, input *goquery.Selection) {
+		if date, err := parseMillis(input.AttrOr("value", "")); err == nil {
+			input.SetAttr("value", formatMillis(date.In(ptime.Iran()).AddDate(0, 0, shift)))
+		}
+	})
+	document.Find(`td[valign="middle"] > div`).Each(func(i int, div *goquery.Selection) {
+		if date, err := parseJalaliDate(strings.TrimSpace(div.Text())); err == nil {
+			div.SetText(formatJalaliDate(date.AddDate(0, 0, shift)))
+		}
+	})
+
+	// Self
+	document.Find("input[name=selectedSelfDefId]").SetAttr("value", userSession.selfID)
+	document.Find(`input[name$=".selfId"]`).SetAttr("value", userSession.selfID)
+	document.Find("select[name=selectedSelfDefIdCombo] option").Each(func(i int, option *goquery.Selection) {
+		if option.AttrOr("value", "") == userSession.selfID {
+			option.SetAttr("selected", "selected")
+		} else {
+			option.RemoveAttr("selected")
+		}
+	})
+
+	// Reservations
+	if reserves := s.weekReserves(userSession.selfID, userSession.week); reserves != nil {
+		for index, reserve := range reserves {
+			s.renderReserve(document, index, reserve)
+		}
+	}
+
+	// Credit
+	document.Find("#creditId").SetText(strconv.Itoa(s.credit))
+	document.Find("input[name=remainCredit]").SetAttr("value", strconv.Itoa(s.credit))
+	if len(message) > 0 {
+		document.Find("body").PrependHtml(`<div id="errorMessages"></div>`)
+		document.Find("#errorMessages").SetText(message)
+	}
+
+	return document.Html()
+}
+
+// renderReserve sets state of food index in its checkbox and inputs
+func (s *Server) renderReserve(document *goquery.Document, index int, reserve reserveState) {
+	prefix := fmt.Sprintf("userWeekReserves[%d]", index)
+	checkbox := document.Find(fmt.Sprintf(`input[type=checkbox][name="%s.selected"]`, prefix))
+	countSelect := document.Find(fmt.Sprintf(`select[name="%s.selectedCount"]`, prefix))
+	document.Find(fmt.Sprintf(`input[name="%s.id"]`, prefix)).SetAttr("value", reserve.id)
+	document.Find(fmt.Sprintf(`input[type=hidden][name="%s.selectedCount"]`, prefix)).Remove()
+
+	options := ""
+	for count := 1; count <= s.MaxCount; count++ {
+		selected := ""
+		if count == reserve.count {
+			selected = ` selected="selected"`
+		}
+		options += fmt.Sprintf(`<option value="%d"%s>%d</option>`, count, selected, count)
+	}
+	countSelect.SetHtml(options)
+
+	if reserve.count > 0 {
+		checkbox.SetAttr("checked", "checked")
+		countSelect.RemoveAttr("disabled")
+	} else {
+		checkbox.RemoveAttr("checked")
+		countSelect.SetAttr("disabled", "")
+	}
+}
+
+func (s *Server) newSession(w http.ResponseWriter) *session {
+	id := newToken()
+	userSession := &session{csrf: newToken()}
+	s.sessions[id] = userSession
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: id, Path: "/"})
+	return userSession
+}
+
+func (s *Server) session(r *http.Request) *session {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	return s.sessions[cookie.Value]
+}
+
+// loadFixture reads a reservation page and foods of it
+func loadFixture(path string) (*fixture, error) {
+	html, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	page := &fixture{html: string(html)}
+	if csrf := csrfRegex.FindStringSubmatch(page.html); len(csrf) > 1 {
+		page.csrf = csrf[1]
+	}
+	if len(page.csrf) == 0 {
+		return nil, fmt.Errorf("%v has no CSRF token", path)
+	}
+
+	document, err := goquery.NewDocumentFromReader(strings.NewReader(page.html))
+	if err != nil {
+		return nil, err
+	}
+	weekStart, err := parseMillis(document.Find("input[name=weekStartDateTime]").First().AttrOr("value", ""))
+	if err != nil {
+		return nil, fmt.Errorf("%v has no week start, %v", path, err)
+	}
+	// Samad may put the time page is loaded in week start
+	page.weekStart = weekStartOf(weekStart)
+	document.Find("select[name=selectedSelfDefIdCombo] option").Each(func(i int, option *goquery.Selection) {
+		page.selves = append(page.selves, option.AttrOr("value", ""))
+	})
+	if len(page.selves) == 0 {
+		return nil, fmt.Errorf("%v has no self", path)
+	}
+
+	var parseErr error
+	document.Find("input[type=checkbox]").Each(func(i int, checkbox *goquery.Selection) {
+		match := reserveNameRegex.FindStringSubmatch(checkbox.AttrOr("name", ""))
+		if match == nil {
+			return
+		}
+		index, _ := strconv.Atoi(match[1])
+		priceFields := strings.Fields(checkbox.SiblingsFiltered("div").Text())
+		if len(priceFields) == 0 {
+			parseErr = fmt.Errorf("food %d of %v has no price", index, path)
+			return
+		}
+		price, err := strconv.Atoi(priceFields[0])
+		if err != nil {
+			parseErr = err
+			return
+		}
+		for len(page.prices) <= index {
+			page.prices = append(page.prices, 0)
+			page.locked = append(page.locked, false)
+			page.meals = append(page.meals, "")
+		}
+		page.prices[index] = price
+		_, page.locked[index] = checkbox.Attr("disabled")
+		prefix := fmt.Sprintf("userWeekReserves[%d]", index)
+		page.meals[index] = document.Find(fmt.Sprintf(`input[name="%s.programDateTime"]`, prefix)).AttrOr("value", "") +
+			"#" + document.Find(fmt.Sprintf(`input[name="%s.mealTypeId"]`, prefix)).AttrOr("value", "")
+	})
+	return page, parseErr
+}
+
+// newCaptchaImage creates a JPEG captcha, its text isn't drawn since clients
+// under test solve it with CaptchaAnswer
+func newCaptchaImage() ([]byte, error) {
+	captcha := image.NewGray(image.Rect(0, 0, 60, 20))
+	for x := 0; x < 60; x++ {
+		for y := 0; y < 20; y++ {
+			captcha.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, captcha, nil); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+var tokenCounter struct {
+	sync.Mutex
+	last int
+}
+
+// newToken creates a unique token for sessions and CSRF
+func newToken() string {
+	tokenCounter.Lock()
+	defer tokenCounter.Unlock()
+	tokenCounter.last++
+	return fmt.Sprintf("%08x-fake-%d", time.Now().UnixNano()&0xffffffff, tokenCounter.last)
+}
+
+func hasSelf(selves []string, selfID string) bool {
+	for _, self := range selves {
+		if self == selfID {
+			return true
+		}
+	}
+	return false
+}
+
+// weekStartOf returns Saturday 00:00 of week of t in Iran
+func weekStartOf(t time.Time) time.Time {
+	date := ptime.New(t.In(ptime.Iran()))
+	saturday := ptime.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, ptime.Iran()).Time()
+	return saturday.AddDate(0, 0, -int(date.Weekday()))
+}
+
+// weekOf returns week of t relative to the current week
+func weekOf(t time.Time) int {
+	days := weekStartOf(t).Sub(weekStartOf(time.Now())).Hours() / 24
+	return int(math.Floor(days/7 + 0.5))
+}
+
+func parseMillis(value string) (time.Time, error) {
+	millis, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(millis/1000, (millis%1000)*int64(time.Millisecond)), nil
+}
+
+func formatMillis(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// parseJalaliDate parses dates like 1396/07/29 to their start in Iran
+func parseJalaliDate(date string) (time.Time, error) {
+	var year, month, day int
+	if _, err := fmt.Sscanf(date, "%d/%d/%d", &year, &month, &day); err != nil {
+		return time.Time{}, err
+	}
+	return ptime.Date(year, ptime.Month(month), day, 0, 0, 0, 0, ptime.Iran()).Time(), nil
+}
+
+func formatJalaliDate(date time.Time) string {
+	jalaliDate := ptime.New(date.In(ptime.Iran()))
+	return fmt.Sprintf("%d/%02d/%02d", jalaliDate.Year(), int(jalaliDate.Month()), jalaliDate.Day())
+}
@@ -0,0 +1,373 @@
+// Package faketelegram is a fake Telegram Bot API, so bots can be tested
+// without network. It records what bots send and delivers messages and
+// callback queries which tests inject as updates.
+package faketelegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	telegramAPI "gopkg.in/telegram-bot-api.v4"
+)
+
+const (
+	// APIHost is host of Telegram Bot API which Transport redirects to Server
+	APIHost = "api.telegram.org"
+
+	// maxPollTimeout is the longest getUpdates waits for new updates
+	maxPollTimeout = 5 * time.Second
+)
+
+// Call is a request of bot to Telegram Bot API
+type Call struct {
+	Method string
+	Params url.Values
+	// File is the uploaded file of sendPhoto
+	File []byte
+	// MessageID is ID of the message which is sent or edited by call
+	MessageID int
+}
+
+// InlineKeyboard returns inline keyboard of the message which is sent or
+// edited by call, it's nil if call has no inline keyboard
+func (c *Call) InlineKeyboard() *telegramAPI.InlineKeyboardMarkup {
+	var keyboard telegramAPI.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(c.Params.Get("reply_markup")), &keyboard); err != nil {
+		return nil
+	}
+	if keyboard.InlineKeyboard == nil {
+		return nil
+	}
+	return &keyboard
+}
+
+// ButtonData returns callback data of the first inline button whose data
+// starts with prefix
+func (c *Call) ButtonData(prefix string) (string, bool) {
+	keyboard := c.InlineKeyboard()
+	if keyboard == nil {
+		return "", false
+	}
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil && strings.HasPrefix(*button.CallbackData, prefix) {
+				return *button.CallbackData, true
+			}
+		}
+	}
+	return "", false
+}
+
+// Server is a fake Telegram Bot API of a bot whose token is Token. Chats are
+// private, so ID of chat of each user is ID of the user.
+type Server struct {
+	*httptest.Server
+
+	Token string
+
+	lock          sync.Mutex
+	bot           telegramAPI.User
+	updates       []telegramAPI.Update
+	lastUpdateID  int
+	lastMessageID int
+	lastQueryID   int
+	// newUpdates is closed when an update is added
+	newUpdates chan struct{}
+	calls      chan *Call
+	history    []*Call
+}
+
+// New starts a fake Telegram Bot API of bot with token
+func New(token string) *Server {
+	s := &Server{
+		Token: token,
+		bot: telegramAPI.User{
+			ID:        1,
+			FirstName: "Sarioself",
+			UserName:  "sarioself_bot",
+		},
+		newUpdates: make(chan struct{}),
+		calls:      make(chan *Call, 1024),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Transport returns a RoundTripper which sends requests of Telegram Bot API
+// to server and other requests to fallback
+func (s *Server) Transport(fallback http.RoundTripper) http.RoundTripper {
+	target, _ := url.Parse(s.URL)
+	return &transport{target: target, fallback: fallback}
+}
+
+type transport struct {
+	target   *url.URL
+	fallback http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Host != APIHost {
+		return t.fallback.RoundTrip(r)
+	}
+	redirected := r.Clone(r.Context())
+	redirected.URL.Scheme = t.target.Scheme
+	redirected.URL.Host = t.target.Host
+	redirected.Host = t.target.Host
+	return t.fallback.RoundTrip(redirected)
+}
+
+// SendMessage adds an update of a message which user sends to bot, texts
+// which start with / are commands
+func (s *Server) SendMessage(userID int, text string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	message := s.newMessage(userID, s.user(userID), text)
+	if strings.HasPrefix(text, "/") {
+		command := strings.Fields(text)[0]
+		message.Entities = &[]telegramAPI.MessageEntity{
+			{Type: "bot_command", Offset: 0, Length: len(command)},
+		}
+	}
+	s.addUpdate(telegramAPI.Update{Message: message})
+}
+
+// PressButton adds an update of a callback query which user sends by pressing
+// inline button with data under message of bot
+func (s *Server) PressButton(userID, messageID int, data string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	message := s.newMessage(userID, &s.bot, "")
+	message.MessageID = messageID
+	s.lastQueryID++
+	s.addUpdate(telegramAPI.Update{
+		CallbackQuery: &telegramAPI.CallbackQuery{
+			ID:           strconv.Itoa(s.lastQueryID),
+			From:         s.user(userID),
+			Message:      message,
+			ChatInstance: strconv.Itoa(userID),
+			Data:         data,
+		},
+	})
+}
+
+// NextCall returns the oldest call of bot which isn't returned yet, it waits
+// at most timeout for bot to call
+func (s *Server) NextCall(timeout time.Duration) (*Call, error) {
+	select {
+	case call := <-s.calls:
+		return call, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("bot didn't call Telegram Bot API in %v", timeout)
+	}
+}
+
+// Calls returns all calls of bot to method
+func (s *Server) Calls(method string) []*Call {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var calls []*Call
+	for _, call := range s.history {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/bot")
+	separator := strings.LastIndex(path, "/")
+	if separator < 0 || path[:separator] != s.Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	method := path[separator+1:]
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch method {
+	case "getMe":
+		writeResult(w, s.bot)
+	case "getUpdates":
+		s.handleGetUpdates(w, r)
+	case "sendMessage", "editMessageText", "editMessageReplyMarkup":
+		s.handleMessage(w, r, method)
+	case "sendPhoto":
+		s.handlePhoto(w, r)
+	case "answerCallbackQuery":
+		s.record(&Call{Method: method, Params: r.Form})
+		writeResult(w, true)
+	default:
+		writeError(w, http.StatusNotFound, "Not Found: method not found")
+	}
+}
+
+func (s *Server) handleGetUpdates(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+	wait := time.Duration(timeout) * time.Second
+	if wait > maxPollTimeout {
+		wait = maxPollTimeout
+	}
+	deadline := time.After(wait)
+
+	for {
+		s.lock.Lock()
+		var updates []telegramAPI.Update
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				updates = append(updates, update)
+			}
+		}
+		newUpdates := s.newUpdates
+		s.lock.Unlock()
+
+		if len(updates) > 0 {
+			writeResult(w, updates)
+			return
+		}
+		select {
+		case <-newUpdates:
+		case <-deadline:
+			writeResult(w, []telegramAPI.Update{})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleMessage sends or edits a text message
+func (s *Server) handleMessage(w http.ResponseWriter, r *http.Request, method string) {
+	chatID, err := strconv.Atoi(r.FormValue("chat_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+		return
+	}
+
+	s.lock.Lock()
+	message := s.newMessage(chatID, &s.bot, r.FormValue("text"))
+	if method != "sendMessage" {
+		message.MessageID, err = strconv.Atoi(r.FormValue("message_id"))
+		if err != nil || message.MessageID <= 0 || message.MessageID > s.lastMessageID {
+			s.lock.Unlock()
+			writeError(w, http.StatusBadRequest, "Bad Request: message to edit not found")
+			return
+		}
+	}
+	s.lock.Unlock()
+
+	s.record(&Call{Method: method, Params: r.Form, MessageID: message.MessageID})
+	writeResult(w, message)
+}
+
+func (s *Server) handlePhoto(w http.ResponseWriter, r *http.Request) {
+	chatID, err := strconv.Atoi(r.FormValue("chat_id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: chat not found")
+		return
+	}
+	photo, _, err := r.FormFile("photo")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Bad Request: there is no photo in the request")
+		return
+	}
+	defer photo.Close()
+	file, err := ioutil.ReadAll(photo)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.lock.Lock()
+	message := s.newMessage(chatID, &s.bot, "")
+	message.Caption = r.FormValue("caption")
+	message.Photo = &[]telegramAPI.PhotoSize{
+		{FileID: "photo" + strconv.Itoa(message.MessageID), FileSize: len(file)},
+	}
+	s.lock.Unlock()
+
+	s.record(&Call{Method: "sendPhoto", Params: r.Form, File: file, MessageID: message.MessageID})
+	writeResult(w, message)
+}
+
+func (s *Server) record(call *Call) {
+	s.lock.Lock()
+	s.history = append(s.history, call)
+	s.lock.Unlock()
+	s.calls <- call
+}
+
+// addUpdate should be called while lock is held
+func (s *Server) addUpdate(update telegramAPI.Update) {
+	s.lastUpdateID++
+	update.UpdateID = s.lastUpdateID
+	s.updates = append(s.updates, update)
+	close(s.newUpdates)
+	s.newUpdates = make(chan struct{})
+}
+
+// newMessage creates a message in private chat of user with a new ID, it
+// should be called while lock is held
+func (s *Server) newMessage(chatID int, from *telegramAPI.User, text string) *telegramAPI.Message {
+	s.lastMessageID++
+	return &telegramAPI.Message{
+		MessageID: s.lastMessageID,
+		From:      from,
+		Date:      int(time.Now().Unix()),
+		Chat: &telegramAPI.Chat{
+			ID:   int64(chatID),
+			Type: "private",
+		},
+		Text: text,
+	}
+}
+
+func (s *Server) user(userID int) *telegramAPI.User {
+	return &telegramAPI.User{
+		ID:        userID,
+		FirstName: "User " + strconv.Itoa(userID),
+	}
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	encodedResult, err := json.Marshal(result)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeResponse(w, http.StatusOK, telegramAPI.APIResponse{Ok: true, Result: encodedResult})
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	writeResponse(w, status, telegramAPI.APIResponse{
+		Ok:          false,
+		ErrorCode:   status,
+		Description: description,
+	})
+}
+
+func writeResponse(w http.ResponseWriter, status int, response telegramAPI.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
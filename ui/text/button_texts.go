@@ -1,9 +1,36 @@
 package text
 
 const (
-	MsgKeyboardFoodItem  = "%s - %s"
-	FoodInlineButtonData = "RES#%s#%s"
+	MsgKeyboardFoodItem              = "%s - %s"
+	MsgKeyboardCancelFoodItem        = "❌ %s - %s"
+	FoodInlineButtonData             = "RES#%s#%s#%s"
+	FoodCancelInlineButtonData       = "CNL#%s#%s#%s"
+	MsgKeyboardNextWeek              = "« هفتهٔ بعد"
+	MsgKeyboardPreviousWeek          = "هفتهٔ قبل »"
+	WeekInlineButtonData             = "WEEK#%d#%s"
+	MsgKeyboardSelf                  = "🍽 %s"
+	MenuInlineButtonData             = "MENU#%s"
+	MsgKeyboardDefaultSelf           = "✅ %s"
+	DefaultSelfInlineButtonData      = "DEFSELF#%s"
+	MsgKeyboardChangeSelf            = "🔁 تغییر سلف"
+	ChangeSelfInlineButtonData       = "CHS#%s#%s#%s"
+	NewSelfInlineButtonData          = "MOV#%s#%s#%s#%s"
+	MsgKeyboardFoodCount             = "🔢 چند پرس؟"
+	FoodCountInlineButtonData        = "QTY#%s#%s#%s#%d"
+	MsgKeyboardPortions              = "%d پرس"
+	FoodReserveCountInlineButtonData = "RES#%s#%s#%s#%d"
+
+	MsgKeyboardStartSelection        = "☑️ انتخاب چندتایی"
+	StartSelectionInlineButtonData   = "BATCH"
+	MsgKeyboardSelectedFoodItem      = "☑️ %s - %s"
+	MsgKeyboardNotSelectedFoodItem   = "⬜️ %s - %s"
+	SelectFoodInlineButtonData       = "TCK#%d"
+	MsgKeyboardConfirmSelection      = "✅ ثبت (%d)"
+	ConfirmSelectionInlineButtonData = "CFM"
+	MsgKeyboardCancelSelection       = "انصراف"
+	CancelSelectionInlineButtonData  = "BCL"
 
 	MsgMainKeyboardCredit = "اعتبار"
 	MsgMainKeyboardMenu   = "منو"
+	MsgMainKeyboardSelf   = "سلف"
 )
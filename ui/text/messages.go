@@ -1,15 +1,69 @@
 package text
 
 const (
-	MsgWelcome                  = "هلو!"
-	MsgAnErrorOccured           = "خطایی رخ داده! دوباره امتحان کن!"
-	MsgEnterStudentID           = "لطفا شمارهٔ دانشجوییت رو وارد کن"
-	MsgEnterPassword            = "لطفا رمز سامانهٔ سفارش غذات رو وارد کن"
-	MsgProfileSuccess           = "ردیف شد!"
-	MsgReservationToggleSuccess = "حله"
-
-	MsgNotSelectedFoodMenuItem   = "🔴 %s %s:\n %s(%s) - %sریال\n\n"
-	MsgSelectedFoodMenuItem      = "🔵 %s %s:\n %s(%s) - %sریال\n\n"
-	MsgNotSelectableFoodMenuItem = "⚪️ %s %s:\n %s(%s) - %sریال\n\n"
+	MsgWelcome                 = "هلو!"
+	MsgAnErrorOccured          = "خطایی رخ داده! دوباره امتحان کن!"
+	MsgEnterStudentID          = "لطفا شمارهٔ دانشجوییت رو وارد کن"
+	MsgEnterPassword           = "لطفا رمز سامانهٔ سفارش غذات رو وارد کن"
+	MsgEnterReservationService = "سامانهٔ سفارش غذای کدوم دانشگاه؟ یکی از اینا رو بفرست:\n%s"
+	MsgProfileSuccess          = "ردیف شد!"
+	MsgReservationSuccess      = "حله، رزرو شد"
+	MsgCancellationSuccess     = "حله، لغو شد"
+	MsgFoodNotFound            = "این غذا دیگه توی منو نیست! منو رو دوباره بگیر"
+	MsgFoodUnavailable         = "دیگه نمی‌شه رزرو این غذا رو عوض کرد!"
+	MsgInvalidWeek             = "نفهمیدم کدوم هفته! مثلا «/menu 1» برای هفتهٔ بعد، «/menu -1» برای هفتهٔ قبل یا «/menu 1396/08/06» رو بفرست"
+	MsgWeekOutOfRange          = "این هفته خیلی دوره! فقط منوی سه ماه قبل و بعد رو می‌تونم نشون بدم"
+	MsgChooseDefaultSelf       = "منو و رزروها از کدوم سلف باشه؟"
+	MsgDefaultSelfSaved        = "حله، از این به بعد منوی این سلف رو نشونت می‌دم"
+	MsgSelfNotFound            = "این سلف توی سامانه نیست! با /self یه سلف دیگه انتخاب کن"
+	MsgChooseNewSelf           = "رزرو به کدوم سلف منتقل بشه؟"
+	MsgSelfChangeSuccess       = "حله، سلف رزرو عوض شد"
+	MsgSelfChangeFailed        = "سامانه سلف رزرو رو عوض نکرد! رزروت توی همون سلف قبلی مونده"
+	MsgChangeSelfUnsupported   = "فعلاً نمی‌تونم سلف رزرو رو عوض کنم، رزروت رو لغو کن و از سلف جدید رزرو کن"
+	MsgFoodNotReserved         = "این غذا رزرو نشده! منو رو دوباره بگیر"
+	MsgChooseCount             = "چند پرس رزرو کنم؟"
+	MsgReservationCountSuccess = "حله، %d پرس رزرو شد"
+	MsgCountNotAllowed         = "سامانه اجازهٔ رزرو این تعداد پرس رو نمی‌ده!"
+	MsgSelectionExpired        = "این منو قدیمیه! منو رو دوباره بگیر"
+	MsgNoSelectionChanges      = "چیزی رو عوض نکردی!"
+	MsgSelectionSuccess        = "حله، %d تا تغییر ثبت شد"
+	MsgInvalidCredentials      = "شمارهٔ دانشجویی یا رمز سامانه‌ات اشتباهه!"
+	MsgWrongCaptcha            = "نتونستم کد امنیتی سامانه رو بخونم! دوباره امتحان کن!"
+	MsgAccountLocked           = "حسابت توی سامانهٔ سفارش غذا قفل شده!"
+	MsgSessionExpired          = "ارتباط با سامانه قطع شد! دوباره امتحان کن!"
+	MsgSelfserviceTimeout      = "سامانهٔ سفارش غذا جواب نمی‌ده! یه کم دیگه امتحان کن!"
+	MsgSelfserviceUnreachable  = "نمی‌تونم به سامانهٔ سفارش غذا وصل شم! یه کم دیگه امتحان کن!"
+	MsgSelfserviceUnavailable  = "سامانهٔ سفارش غذا خرابه! یه کم دیگه امتحان کن!"
+	MsgSelfserviceChanged      = "سامانهٔ سفارش غذا عوض شده و نمی‌تونم بخونمش! به زودی درستش می‌کنیم"
+	MsgEnterCaptcha            = "نتونستم کد امنیتی رو بخونم! لطفا کد توی عکس رو برام بفرست"
+
+	MsgReservationRejected         = "سامانه رزرو رو قبول نکرد!"
+	MsgReservationPartiallyApplied = "فقط %d تا از %d تغییر توی سامانه ثبت شد!"
+	MsgReservationRejectionReason  = "\nسامانه می‌گه: %s"
+	MsgRemainingCredit             = "\nاعتبار باقی‌مونده: %s تومان"
+	MsgCredit                      = "%s تومان"
+
+	MsgParseFailureAlert      = "⚠️ نتونستم صفحهٔ سامانهٔ %s رو بخونم! احتمالا ظاهرش عوض شده. اینا پیدا نشدن:\n%s"
+	MsgParseFailureField      = "- %s (%s)"
+	MsgParseFailureSnapshot   = "\n\nصفحه اینجا ذخیره شد: %s"
+	MsgParseFailureSuppressed = "\n\n%d خطای دیگه هم از هشدار قبلی تا حالا بوده"
+
+	MsgNotSelectedFoodMenuItem   = "🔴 %s %s:\n %s(%s) - %s تومان%s\n\n"
+	MsgSelectedFoodMenuItem      = "🔵 %s %s:\n %s(%s) - %s تومان%s\n\n"
+	MsgNotSelectableFoodMenuItem = "⚪️ %s %s:\n %s(%s) - %s تومان%s\n\n"
 	MsgNoSideDish                = "بدون مخلفات"
+	MsgWeekMenuTitle             = "منوی هفتهٔ %s:\n\n"
+	MsgEmptyWeekMenu             = "این هفته غذایی توی منو نیست"
+	MsgMenuSelfTitle             = "🍽 %s\n\n"
+	MsgFoodCount                 = "%s (%d پرس)"
+)
+
+const (
+	MsgFoodChangeableFor = "\n ⏳ %s وقت داری عوضش کنی"
+	MsgFoodLocked        = "\n 🔒 دیگه نمی‌شه عوضش کرد"
+	MsgFoodIsLocked      = "مهلت عوض کردن این غذا تموم شده!"
+	MsgDays              = "%d روز"
+	MsgHours             = "%d ساعت"
+	MsgMinutes           = "%d دقیقه"
+	MsgDurationSeparator = " و "
 )